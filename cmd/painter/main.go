@@ -3,25 +3,78 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
+	"image"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/gothicenemy/software-architecture-3/painter"
-	"github.com/gothicenemy/software-architecture-3/painter/lang"
+	"github.com/gothicenemy/software-architecture-3/painter/headless"
+	"github.com/gothicenemy/software-architecture-3/painter/journal"
 	"github.com/gothicenemy/software-architecture-3/ui"
 
 	"golang.org/x/exp/shiny/driver"
 	"golang.org/x/exp/shiny/screen"
 )
 
-const HttpPort = ":17000"
+// parseSize parses a "WxH" size such as "1024x768".
+func parseSize(s string) (image.Point, error) {
+	w, h, ok := strings.Cut(strings.ToLower(s), "x")
+	if !ok {
+		return image.Point{}, fmt.Errorf("invalid size %q, expected WxH", s)
+	}
+	var size image.Point
+	var errW, errH error
+	size.X, errW = strconv.Atoi(w)
+	size.Y, errH = strconv.Atoi(h)
+	if errW != nil || errH != nil || size.X <= 0 || size.Y <= 0 {
+		return image.Point{}, fmt.Errorf("invalid size %q, expected WxH", s)
+	}
+	return size, nil
+}
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "render" {
+		if err := runRender(os.Args[2:], os.Stderr); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				os.Exit(2)
+			}
+			fmt.Fprintf(os.Stderr, "painter render: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	presentFlag := flag.String("present", "fit", "how a fixed -canvas is shown in the window: stretch, fit, fill or center")
+	canvasFlag := flag.String("canvas", "", "fixed canvas size WxH; by default the canvas follows the window size")
+	sceneDirFlag := flag.String("scene-dir", "scenes", "directory for files written and read by the save and load commands")
+	headlessFlag := flag.Bool("headless", false, "render in memory without opening a window; only the HTTP API is served")
+	queueFlag := flag.Int("queue", painter.DefaultQueueCapacity, "number of operations that may wait for the painter loop")
+	historyFlag := flag.Int("history", painter.DefaultHistoryDepth, "number of changes that can be undone; 0 disables undo")
+	journalFlag := flag.String("journal", "", "file recording every scene change; it is replayed on startup so the scene survives restarts and crashes")
+	journalCompactFlag := flag.Int("journal-compact", journal.DefaultCompactEvery, "number of journal lines after which the journal is rewritten as a snapshot")
+	maxFPSFlag := flag.Int("max-fps", 0, "maximum number of frames presented per second; updates in between are coalesced, 0 means no limit")
+	flag.Parse()
+
+	present, err := ui.ParsePresentMode(*presentFlag)
+	if err != nil {
+		log.Fatalf("Invalid -present: %v", err)
+	}
+	var canvasSize image.Point
+	if *canvasFlag != "" {
+		if canvasSize, err = parseSize(*canvasFlag); err != nil {
+			log.Fatalf("Invalid -canvas: %v", err)
+		}
+	}
+
 	log.Println("Starting Painter application (final structure)...")
 
 	sigChan := make(chan os.Signal, 1)
@@ -29,99 +82,79 @@ func main() {
 
 	var (
 		painterLoop *painter.Loop
-		window      *ui.Window
 		server      *http.Server
+		sceneLog    *journal.Journal
 	)
 
-	driverDone := make(chan struct{})
 	shutdownRequest := make(chan struct{})
+	var shutdownOnce sync.Once
+	requestShutdown := func() {
+		shutdownOnce.Do(func() { close(shutdownRequest) })
+	}
 
 	go func() {
 		select {
 		case sig := <-sigChan:
 			log.Printf("Received OS signal: %v. Initiating shutdown...", sig)
-			close(shutdownRequest)
-		case <-driverDone:
-			log.Println("Signal handler noticed driver finished.")
+			requestShutdown()
+		case <-shutdownRequest:
 		}
 	}()
 
-	go func() {
-		time.Sleep(200 * time.Millisecond)
-		if painterLoop == nil {
-			log.Println("HTTP Server: Painter loop is nil, server not starting.")
-			return
-		}
-
-		parser := &lang.Parser{}
-		server = &http.Server{Addr: HttpPort}
-
-		http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-			if painterLoop == nil {
-				http.Error(w, "Painter loop not ready", http.StatusServiceUnavailable)
-				return
-			}
-			if r.Method != http.MethodPost {
-				http.Error(w, "Only POST method is accepted", http.StatusMethodNotAllowed)
-				return
-			}
-			defer r.Body.Close()
-			cmds, err := parser.Parse(r.Body)
-			if err != nil {
-				http.Error(w, fmt.Sprintf("Error parsing commands: %v", err), http.StatusBadRequest)
-				return
-			}
-			for _, cmd := range cmds {
-				painterLoop.Post(cmd)
-			}
-			w.WriteHeader(http.StatusOK)
-			fmt.Fprintln(w, "Commands processed")
-		})
-
-		log.Printf("Starting HTTP server on port %s", HttpPort)
-		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			log.Printf("HTTP server ListenAndServe error: %v", err)
-			select {
-			case <-shutdownRequest:
-			default:
-				close(shutdownRequest)
+	startLoop := func(s screen.Screen) {
+		painterLoop = painter.NewLoop(s,
+			painter.WithQueueCapacity(*queueFlag),
+			painter.WithSceneDir(*sceneDirFlag),
+			painter.WithHistoryDepth(*historyFlag),
+			painter.WithMaxFPS(*maxFPSFlag),
+		)
+		if *journalFlag != "" {
+			var err error
+			if sceneLog, err = journal.Restore(painterLoop, *journalFlag, *journalCompactFlag); err != nil {
+				log.Fatalf("Cannot open journal: %v", err)
 			}
 		}
-		log.Println("HTTP server stopped.")
-	}()
-
-	driver.Main(func(s screen.Screen) {
-		log.Println("Driver started.")
-		painterLoop = painter.NewLoop(s)
 		go painterLoop.Start()
 		log.Println("Painter loop created and started.")
-
-		window = ui.NewWindow(s, painterLoop)
-		if window == nil {
-			log.Println("Window creation failed.")
-			return
+		if canvasSize != (image.Point{}) {
+			painterLoop.Post(painter.ResizeOperation{Size: canvasSize})
 		}
+		server = startServer(painterLoop, requestShutdown)
+	}
 
-		go func() {
-			select {
-			case <-shutdownRequest:
-				log.Println("Shutdown request received, stopping window...")
-				window.Stop()
-			case <-window.Closed():
-				log.Println("Window closed by user, signaling shutdown.")
+	if *headlessFlag {
+		log.Println("Running headless, no window will be opened.")
+		startLoop(headless.NewScreen())
+		<-shutdownRequest
+	} else {
+		driver.Main(func(s screen.Screen) {
+			log.Println("Driver started.")
+			startLoop(s)
+
+			window := ui.NewWindow(s, painterLoop)
+			if window == nil {
+				log.Println("Window creation failed.")
+				return
+			}
+			window.Present = present
+			window.ResizeCanvas = canvasSize == (image.Point{})
+
+			go func() {
 				select {
 				case <-shutdownRequest:
-				default:
-					close(shutdownRequest)
+					log.Println("Shutdown request received, stopping window...")
+					window.Stop()
+				case <-window.Closed():
+					log.Println("Window closed by user, signaling shutdown.")
+					requestShutdown()
 				}
-			}
-		}()
+			}()
 
-		window.Loop()
-		log.Println("Window loop finished.")
-	})
-
-	close(driverDone)
+			window.Loop()
+			log.Println("Window loop finished.")
+		})
+		requestShutdown()
+	}
 
 	log.Println("Starting graceful shutdown...")
 
@@ -144,5 +177,13 @@ func main() {
 		log.Println("Painter loop confirmed stopped.")
 	}
 
+	if sceneLog != nil {
+		if err := sceneLog.Close(); err != nil {
+			log.Printf("Journal close error: %v", err)
+		} else {
+			log.Println("Journal closed.")
+		}
+	}
+
 	log.Println("Painter application finished.")
 }
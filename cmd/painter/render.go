@@ -0,0 +1,206 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gothicenemy/software-architecture-3/painter"
+	"github.com/gothicenemy/software-architecture-3/painter/headless"
+	"github.com/gothicenemy/software-architecture-3/painter/lang"
+)
+
+const renderUsage = `usage: painter render [flags] script.txt
+
+Renders a script offline and writes the final frame to an image file.
+The output extension selects the format: .png, .jpeg, .bmp or .svg.
+Use "-" as the script name to read from standard input.
+
+`
+
+// parseInterspersed parses flags that may appear before or after the
+// positional arguments and returns the positional ones.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+func runRender(args []string, stderr io.Writer) error {
+	fs := flag.NewFlagSet("render", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	out := fs.String("o", "out.png", "output image; the extension selects the format (png, jpeg, bmp, svg)")
+	sizeFlag := fs.String("size", "800x800", "canvas size WxH")
+	everyUpdate := fs.Bool("every-update", false, "write a numbered frame (out-001.png, ...) for every update instead of the final frame")
+	strict := fs.Bool("strict", false, "reject the script if it produces any diagnostics")
+	fs.Usage = func() {
+		fmt.Fprint(stderr, renderUsage)
+		fs.PrintDefaults()
+	}
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		return fmt.Errorf("render expects exactly one script, got %d", len(positional))
+	}
+	size, err := parseSize(*sizeFlag)
+	if err != nil {
+		return err
+	}
+	write, err := frameWriterFor(*out)
+	if err != nil {
+		return err
+	}
+
+	scriptName := positional[0]
+	var script io.Reader = os.Stdin
+	if scriptName != "-" {
+		f, err := os.Open(scriptName)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		script = f
+	}
+
+	parser := &lang.Parser{Mode: lang.Lenient}
+	if *strict {
+		parser.Mode = lang.Strict
+	}
+	ops, diags, parseErr := parser.Parse(script)
+	for _, d := range diags {
+		fmt.Fprintf(stderr, "%s:%s\n", scriptName, d)
+	}
+	if parseErr != nil {
+		return parseErr
+	}
+
+	frames := 0
+	var writeErr error
+	capture := func(state *painter.LoopState) {
+		if writeErr != nil {
+			return
+		}
+		frames++
+		path := *out
+		if *everyUpdate {
+			path = framePath(*out, frames)
+		}
+		writeErr = write(path, state)
+	}
+	renderScript(ops, size, *everyUpdate, capture)
+	if writeErr != nil {
+		return writeErr
+	}
+	if frames == 0 {
+		return fmt.Errorf("%s has no update commands, no frames written", scriptName)
+	}
+	return nil
+}
+
+// renderScript applies ops to a headless loop of the given size and calls
+// capture at every update when everyUpdate is set, or once with the final
+// state otherwise. The loop is stepped on the calling goroutine with a fixed
+// clock, so the output only depends on the script.
+func renderScript(ops []painter.Operation, size image.Point, everyUpdate bool, capture func(state *painter.LoopState)) {
+	loop := painter.NewLoop(headless.NewScreen(), painter.WithClock(painter.NewManualClock(time.Time{})))
+	run := func(op painter.Operation) {
+		// The queue is drained after every post, so there is always room.
+		_ = loop.Post(op)
+		for loop.Step() {
+		}
+	}
+
+	captureOp := painter.OperationFunc(func(state *painter.LoopState) bool {
+		capture(state)
+		return false
+	})
+
+	run(painter.ResizeOperation{Size: size})
+	for _, op := range ops {
+		run(op)
+		if everyUpdate && requestsUpdate(op) {
+			run(captureOp)
+		}
+	}
+	if !everyUpdate {
+		run(captureOp)
+	}
+}
+
+// requestsUpdate reports whether op is an update or a transaction that
+// contains one.
+func requestsUpdate(op painter.Operation) bool {
+	switch op := op.(type) {
+	case painter.UpdateOperation:
+		return true
+	case painter.BatchOperation:
+		for _, child := range op.Ops {
+			if requestsUpdate(child) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// framePath turns "out.png" into "out-007.png" for frame 7.
+func framePath(out string, n int) string {
+	ext := filepath.Ext(out)
+	return fmt.Sprintf("%s-%03d%s", strings.TrimSuffix(out, ext), n, ext)
+}
+
+type frameWriter func(path string, state *painter.LoopState) error
+
+func frameWriterFor(path string) (frameWriter, error) {
+	format := strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	if format == "svg" {
+		return writeSVG, nil
+	}
+	if format == "jpg" {
+		format = "jpeg"
+	}
+	enc, ok := imageEncoders[format]
+	if !ok {
+		return nil, fmt.Errorf("cannot tell the image format of %q, use .png, .jpeg, .bmp or .svg", path)
+	}
+	return func(path string, state *painter.LoopState) error {
+		return writeFile(path, func(w io.Writer) error {
+			return enc.encode(w, painter.RenderImage(state))
+		})
+	}, nil
+}
+
+func writeSVG(path string, state *painter.LoopState) error {
+	return writeFile(path, func(w io.Writer) error {
+		return painter.WriteSVG(w, state)
+	})
+}
+
+func writeFile(path string, write func(w io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	return f.Close()
+}
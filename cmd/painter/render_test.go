@@ -0,0 +1,71 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readPNG(t *testing.T, path string) image.Image {
+	t.Helper()
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	img, err := png.Decode(f)
+	require.NoError(t, err)
+	return img
+}
+
+func TestRunRender(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "script.txt")
+	require.NoError(t, os.WriteFile(script, []byte("reset\nbg red\nupdate\nbg blue\nbgrect 0 0 0.5 0.5 white\nupdate\n"), 0o644))
+
+	out := filepath.Join(dir, "out.png")
+	require.NoError(t, runRender([]string{script, "-o", out, "--size", "40x20"}, io.Discard))
+	img := readPNG(t, out)
+	assert.Equal(t, image.Rect(0, 0, 40, 20), img.Bounds())
+	assert.Equal(t, color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}, color.NRGBAModel.Convert(img.At(5, 5)))
+	assert.Equal(t, color.NRGBA{B: 0xff, A: 0xff}, color.NRGBAModel.Convert(img.At(30, 15)))
+
+	require.NoError(t, runRender([]string{"-every-update", "-size=40x20", "-o", out, script}, io.Discard))
+	assert.Equal(t, color.NRGBA{R: 0xff, A: 0xff}, color.NRGBAModel.Convert(readPNG(t, filepath.Join(dir, "out-001.png")).At(30, 15)))
+	assert.Equal(t, color.NRGBA{B: 0xff, A: 0xff}, color.NRGBAModel.Convert(readPNG(t, filepath.Join(dir, "out-002.png")).At(30, 15)))
+	assert.NoFileExists(t, filepath.Join(dir, "out-003.png"))
+
+	svg := filepath.Join(dir, "out.svg")
+	require.NoError(t, runRender([]string{script, "-o", svg, "--size", "40x20"}, io.Discard))
+	data, err := os.ReadFile(svg)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `<svg xmlns="http://www.w3.org/2000/svg" width="40" height="20"`)
+	assert.Contains(t, string(data), `<rect class="bgrect" x="0" y="0" width="20" height="10" fill="#ffffff"/>`)
+}
+
+func TestRunRender_Errors(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "script.txt")
+	require.NoError(t, os.WriteFile(script, []byte("figure 0.5\n"), 0o644))
+
+	assert.Error(t, runRender([]string{}, io.Discard))
+	assert.Error(t, runRender([]string{script, "-o", filepath.Join(dir, "out.gif")}, io.Discard))
+	assert.Error(t, runRender([]string{script, "-size", "big"}, io.Discard))
+	assert.Error(t, runRender([]string{script, "-strict", "-o", filepath.Join(dir, "out.png")}, io.Discard))
+	assert.NoFileExists(t, filepath.Join(dir, "out.png"))
+}
+
+func TestParseSize(t *testing.T) {
+	size, err := parseSize("1024x768")
+	require.NoError(t, err)
+	assert.Equal(t, image.Pt(1024, 768), size)
+	for _, s := range []string{"", "1024", "0x10", "ax10", "10x-1"} {
+		_, err := parseSize(s)
+		assert.Error(t, err, s)
+	}
+}
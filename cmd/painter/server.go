@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gothicenemy/software-architecture-3/painter"
+	"github.com/gothicenemy/software-architecture-3/painter/lang"
+
+	"golang.org/x/image/bmp"
+)
+
+const (
+	HttpPort = ":17000"
+	// loopTimeout bounds how long a request waits for the painter loop.
+	loopTimeout = 5 * time.Second
+)
+
+type server struct {
+	loop *painter.Loop
+}
+
+func newServer(loop *painter.Loop) *http.Server {
+	s := &server{loop: loop}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleScript)
+	mux.HandleFunc("/snapshot.png", s.handleSnapshot)
+	mux.HandleFunc("/snapshot.svg", s.handleSVGSnapshot)
+	mux.HandleFunc("/state", s.handleState)
+	mux.HandleFunc("/undo", s.handleHistory(painter.UndoOperation{}))
+	mux.HandleFunc("/redo", s.handleHistory(painter.RedoOperation{}))
+	return &http.Server{Addr: HttpPort, Handler: mux}
+}
+
+// startServer runs the HTTP server in the background and calls onFail if it
+// stops for any reason other than a shutdown.
+func startServer(loop *painter.Loop, onFail func()) *http.Server {
+	srv := newServer(loop)
+	go func() {
+		log.Printf("Starting HTTP server on port %s", HttpPort)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("HTTP server ListenAndServe error: %v", err)
+			onFail()
+		}
+		log.Println("HTTP server stopped.")
+	}()
+	return srv
+}
+
+type parseResponse struct {
+	Operations  int              `json:"operations"`
+	Applied     int              `json:"applied"`
+	Results     []opResult       `json:"results,omitempty"`
+	Diagnostics lang.Diagnostics `json:"diagnostics"`
+	Error       string           `json:"error,omitempty"`
+}
+
+// opResult is the outcome of one posted operation.
+type opResult struct {
+	Operation string `json:"operation"`
+	Error     string `json:"error,omitempty"`
+}
+
+// requestMode picks the parse mode from the "mode" query parameter, falling
+// back to the X-Painter-Mode header and then to lenient parsing.
+func requestMode(r *http.Request) (lang.Mode, error) {
+	if m := r.URL.Query().Get("mode"); m != "" {
+		return lang.ParseMode(m)
+	}
+	return lang.ParseMode(r.Header.Get("X-Painter-Mode"))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error writing JSON response: %v", err)
+	}
+}
+
+func (s *server) handleScript(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is accepted", http.StatusMethodNotAllowed)
+		return
+	}
+	defer r.Body.Close()
+	mode, err := requestMode(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	parser := &lang.Parser{Mode: mode}
+	cmds, diags, err := parser.Parse(r.Body)
+	if errors.Is(err, lang.ErrRejected) {
+		writeJSON(w, http.StatusUnprocessableEntity, parseResponse{Diagnostics: diags, Error: err.Error()})
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error parsing commands: %v", err), http.StatusBadRequest)
+		return
+	}
+	results, status := s.apply(r.Context(), cmds)
+	resp := parseResponse{Operations: len(cmds), Results: results, Diagnostics: diags, Applied: applied(results)}
+	if resp.Applied < resp.Operations {
+		resp.Error = fmt.Sprintf("%d of %d operations failed", resp.Operations-resp.Applied, resp.Operations)
+	}
+	if resp.Applied == 0 {
+		askRetry(w, status)
+	}
+	writeJSON(w, status, resp)
+}
+
+// errNotQueued is reported for the operations after one that could not be
+// queued; they are not posted so the script is never applied with gaps.
+var errNotQueued = errors.New("not applied, an earlier operation could not be queued")
+
+// apply posts ops in order and waits until the loop has applied all of them
+// and presented the frames they asked for, held back ones included. It returns the outcome of every operation and the status that
+// sums them up: 409 if an operation failed, 429 if the queue was full and 503
+// if the loop is stopped or did not finish in time. Operations still queued
+// when the time is up are skipped, so none is applied after the response.
+//
+// Only the first operation is refused when the queue is full; the rest wait
+// for room, so scripts longer than the queue are applied whole and in order.
+func (s *server) apply(ctx context.Context, ops []painter.Operation) ([]opResult, int) {
+	ctx, cancel := context.WithTimeout(ctx, loopTimeout)
+	defer cancel()
+	var acks []*painter.Ack
+	for i, op := range ops {
+		var ack *painter.Ack
+		if i == 0 {
+			ack = s.loop.TryPostAckContext(ctx, op)
+		} else {
+			ack = s.loop.PostAckContext(ctx, op)
+		}
+		acks = append(acks, ack)
+		if notQueued(ack) {
+			break
+		}
+	}
+
+	results := make([]opResult, len(ops))
+	status := http.StatusOK
+	for i := range ops {
+		results[i].Operation = describe(ops[i])
+		err := errNotQueued
+		if i < len(acks) {
+			err = acks[i].Wait(ctx)
+		}
+		if err == nil {
+			continue
+		}
+		if st, busy := loopStatus(err); busy {
+			status = st
+		} else if status == http.StatusOK {
+			status = http.StatusConflict
+		}
+		results[i].Error = err.Error()
+	}
+	return results, status
+}
+
+// applied counts the operations in results that were applied. A request
+// that applied some of its operations must not be retried as a whole, or
+// those would be applied twice.
+func applied(results []opResult) int {
+	n := 0
+	for _, res := range results {
+		if res.Error == "" {
+			n++
+		}
+	}
+	return n
+}
+
+// notQueued reports whether ack was resolved because its operation could
+// not be queued.
+func notQueued(ack *painter.Ack) bool {
+	select {
+	case <-ack.Done():
+		_, busy := loopStatus(ack.Err())
+		return busy
+	default:
+		return false
+	}
+}
+
+// loopStatus maps a failure to get work done by the loop to an HTTP status.
+// It reports false for errors of the operations themselves.
+func loopStatus(err error) (int, bool) {
+	switch {
+	case errors.Is(err, painter.ErrQueueFull):
+		return http.StatusTooManyRequests, true
+	case errors.Is(err, painter.ErrStopped), errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return http.StatusServiceUnavailable, true
+	}
+	return http.StatusInternalServerError, false
+}
+
+// loopError replies to a request the loop could not serve, asking the client
+// to retry later.
+func loopError(w http.ResponseWriter, msg string, err error) {
+	status, _ := loopStatus(err)
+	askRetry(w, status)
+	http.Error(w, fmt.Sprintf("%s: %v", msg, err), status)
+}
+
+// askRetry tells the client when to repeat a request that failed because the
+// loop was busy.
+func askRetry(w http.ResponseWriter, status int) {
+	if status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", "1")
+	}
+}
+
+// describe names an operation for API responses.
+func describe(op painter.Operation) string {
+	switch op := op.(type) {
+	case fmt.Stringer:
+		return op.String()
+	case painter.BatchOperation:
+		return fmt.Sprintf("transaction of %d operations", len(op.Ops))
+	default:
+		return strings.TrimPrefix(fmt.Sprintf("%T", op), "painter.")
+	}
+}
+
+// applyOrFail applies ops and replies with 204, or with the first failure.
+func (s *server) applyOrFail(w http.ResponseWriter, r *http.Request, ops ...painter.Operation) {
+	results, status := s.apply(r.Context(), ops)
+	if status == http.StatusOK {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if applied(results) == 0 {
+		askRetry(w, status)
+	}
+	for _, res := range results {
+		if res.Error != "" {
+			http.Error(w, res.Error, status)
+			return
+		}
+	}
+}
+
+// onLoop reads the state with fn on the painter loop goroutine and waits
+// for room in the queue and for fn to finish.
+func (s *server) onLoop(ctx context.Context, fn func(state *painter.LoopState)) error {
+	ctx, cancel := context.WithTimeout(ctx, loopTimeout)
+	defer cancel()
+	return s.loop.Read(ctx, fn)
+}
+
+type imageEncoder struct {
+	contentType string
+	encode      func(w io.Writer, img image.Image) error
+}
+
+var imageEncoders = map[string]imageEncoder{
+	"png": {"image/png", png.Encode},
+	"jpeg": {"image/jpeg", func(w io.Writer, img image.Image) error {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 90})
+	}},
+	"bmp": {"image/bmp", bmp.Encode},
+}
+
+func (s *server) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Only GET method is accepted", http.StatusMethodNotAllowed)
+		return
+	}
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	if format == "" {
+		format = "png"
+	} else if format == "jpg" {
+		format = "jpeg"
+	}
+	enc, ok := imageEncoders[format]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unsupported image format %q, use png, jpeg or bmp", format), http.StatusBadRequest)
+		return
+	}
+	var img *image.RGBA
+	err := s.onLoop(r.Context(), func(state *painter.LoopState) {
+		img = painter.RenderImage(state)
+	})
+	if err != nil {
+		loopError(w, "Painter loop did not render a snapshot", err)
+		return
+	}
+	var buf bytes.Buffer
+	if err := enc.encode(&buf, img); err != nil {
+		http.Error(w, fmt.Sprintf("Error encoding snapshot: %v", err), http.StatusInternalServerError)
+		return
+	}
+	writeSnapshot(w, enc.contentType, &buf)
+}
+
+func (s *server) handleSVGSnapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Only GET method is accepted", http.StatusMethodNotAllowed)
+		return
+	}
+	var buf bytes.Buffer
+	var svgErr error
+	err := s.onLoop(r.Context(), func(state *painter.LoopState) {
+		svgErr = painter.WriteSVG(&buf, state)
+	})
+	if err != nil {
+		loopError(w, "Painter loop did not render a snapshot", err)
+		return
+	}
+	if svgErr != nil {
+		http.Error(w, fmt.Sprintf("Error encoding snapshot: %v", svgErr), http.StatusInternalServerError)
+		return
+	}
+	writeSnapshot(w, "image/svg+xml", &buf)
+}
+
+func writeSnapshot(w http.ResponseWriter, contentType string, buf *bytes.Buffer) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.Header().Set("Cache-Control", "no-store")
+	if _, err := buf.WriteTo(w); err != nil {
+		log.Printf("Error writing snapshot: %v", err)
+	}
+}
+
+const maxStateSize = 8 << 20
+
+func (s *server) handleState(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		ctx, cancel := context.WithTimeout(r.Context(), loopTimeout)
+		defer cancel()
+		snap, err := s.loop.Snapshot(ctx)
+		if err != nil {
+			loopError(w, "Painter loop did not respond", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, snap.Scene)
+	case http.MethodPut:
+		defer r.Body.Close()
+		var sc painter.Scene
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxStateSize)).Decode(&sc); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid scene: %v", err), http.StatusBadRequest)
+			return
+		}
+		s.applyOrFail(w, r, painter.SceneOperation{Scene: sc}, painter.UpdateOperation{})
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT")
+		http.Error(w, "Only GET and PUT methods are accepted", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleHistory returns a handler that applies op (undo or redo) and
+// redraws.
+func (s *server) handleHistory(op painter.Operation) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST method is accepted", http.StatusMethodNotAllowed)
+			return
+		}
+		s.applyOrFail(w, r, op, painter.UpdateOperation{})
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gothicenemy/software-architecture-3/painter"
+	"github.com/gothicenemy/software-architecture-3/painter/headless"
+	"github.com/gothicenemy/software-architecture-3/painter/journal"
+)
+
+func newTestServer(t *testing.T) (*painter.Loop, http.Handler) {
+	t.Helper()
+	loop := painter.NewLoop(headless.NewScreen())
+	go loop.Start()
+	t.Cleanup(loop.Stop)
+	return loop, newServer(loop).Handler
+}
+
+func postScript(t *testing.T, h http.Handler, script string) (int, parseResponse) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(script)))
+	var resp parseResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	return rec.Code, resp
+}
+
+func TestHandleScript_ReportsOutcome(t *testing.T) {
+	_, h := newTestServer(t)
+
+	code, resp := postScript(t, h, "reset\nfigure id=a 0.2 0.2\nupdate")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 3, resp.Applied)
+	assert.Equal(t, "figure id=a 0.2 0.2", resp.Results[1].Operation)
+	assert.Empty(t, resp.Error)
+
+	code, resp = postScript(t, h, "figure id=a 0.3 0.3\nwhite")
+	assert.Equal(t, http.StatusConflict, code)
+	assert.Equal(t, 1, resp.Applied)
+	assert.Contains(t, resp.Results[0].Error, "already used")
+	assert.Empty(t, resp.Results[1].Error)
+	assert.Equal(t, "1 of 2 operations failed", resp.Error)
+}
+
+func TestHandleScript_QueueFull(t *testing.T) {
+	loop := painter.NewLoop(headless.NewScreen())
+	h := newServer(loop).Handler
+	for len(loop.MsgQueue) < cap(loop.MsgQueue) {
+		loop.Post(painter.UpdateOperation{})
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("white\ngreen")))
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+	var resp parseResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal(t, 0, resp.Applied)
+	assert.Equal(t, painter.ErrQueueFull.Error(), resp.Results[0].Error)
+	assert.Equal(t, errNotQueued.Error(), resp.Results[1].Error)
+	assert.Len(t, loop.MsgQueue, cap(loop.MsgQueue), "nothing was queued")
+
+	done := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/snapshot.png", nil))
+		done <- rec.Code
+	}()
+	go loop.Start()
+	defer loop.Stop()
+	assert.Equal(t, http.StatusOK, <-done, "reads wait for room in the queue")
+}
+
+func TestHandleScript_SkipsOperationsOfEndedRequests(t *testing.T) {
+	loop := painter.NewLoop(headless.NewScreen())
+	h := newServer(loop).Handler
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequestWithContext(ctx, http.MethodPost, "/", strings.NewReader("white\nfigure 0.2 0.2"))
+		h.ServeHTTP(rec, req)
+		done <- rec
+	}()
+	require.Eventually(t, func() bool { return len(loop.MsgQueue) == 2 }, time.Second, time.Millisecond)
+	cancel()
+	rec := <-done
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	go loop.Start()
+	defer loop.Stop()
+	snap, err := loop.Snapshot(t.Context())
+	require.NoError(t, err)
+	assert.NotEqual(t, color.White, snap.Background, "queued operations of an ended request are skipped")
+	assert.Len(t, snap.Figures, 1)
+}
+
+func TestHandleScript_LongerThanQueue(t *testing.T) {
+	loop := painter.NewLoop(headless.NewScreen(), painter.WithQueueCapacity(2))
+	go loop.Start()
+	defer loop.Stop()
+	h := newServer(loop).Handler
+
+	var script strings.Builder
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(&script, "figure id=f%d 0.5 0.5\n", i)
+	}
+	code, resp := postScript(t, h, script.String())
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 50, resp.Applied)
+
+	snap, err := loop.Snapshot(t.Context())
+	require.NoError(t, err)
+	require.Len(t, snap.Figures, 51)
+	for i, f := range snap.Figures[1:] {
+		assert.Equal(t, fmt.Sprintf("f%d", i), f.ID, "figures are added in order")
+	}
+}
+
+func TestHandleHistory(t *testing.T) {
+	loop, h := newTestServer(t)
+	postScript(t, h, "white\nupdate")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/undo", nil))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	snap, err := loop.Snapshot(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, color.RGBA{G: 0xff, A: 0xff}, snap.Background)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/undo", nil))
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Contains(t, rec.Body.String(), painter.ErrNothingToUndo.Error())
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/redo", nil))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/redo", nil))
+	assert.Equal(t, http.StatusConflict, rec.Code)
+}
+
+func TestHandleState_PutSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scene.journal")
+	start := func() (*painter.Loop, *journal.Journal) {
+		loop := painter.NewLoop(headless.NewScreen())
+		j, err := journal.Restore(loop, path, 0)
+		require.NoError(t, err)
+		go loop.Start()
+		return loop, j
+	}
+	put := func(h http.Handler, doc string) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/state", strings.NewReader(doc)))
+		return rec.Code
+	}
+
+	loop, j := start()
+	h := newServer(loop).Handler
+	assert.Equal(t, http.StatusBadRequest, put(h, `{"version": 1, "background": "#000", "figures": [{"id": "1a"}]}`))
+	assert.Equal(t, http.StatusBadRequest, put(h, `{"version": 1, "background": "#000", "figures": [{"tags": ["a b"]}]}`))
+	require.Equal(t, http.StatusNoContent, put(h, `{"version": 1, "background": "#000", "figures": [
+		{"x": 0.25, "y": 0.5, "id": "a_1", "tags": ["top", "row.2"]},
+		{"x": 0.75, "y": 0.5, "tags": ["_x-y"]}
+	]}`))
+	want, err := loop.Snapshot(t.Context())
+	require.NoError(t, err)
+	loop.Stop()
+	require.NoError(t, j.Close())
+
+	loop, j = start()
+	defer j.Close()
+	defer loop.Stop()
+	got, err := loop.Snapshot(t.Context())
+	require.NoError(t, err)
+	assert.Len(t, got.Figures, 2)
+	assert.Equal(t, want.Scene, got.Scene)
+}
+
+func TestHandleSnapshot_Formats(t *testing.T) {
+	loop, h := newTestServer(t)
+	postScript(t, h, "white\nfigure 0.5 0.5\nupdate")
+	snap, err := loop.Snapshot(t.Context())
+	require.NoError(t, err)
+
+	for query, want := range map[string]struct{ contentType, format string }{
+		"":             {"image/png", "png"},
+		"?format=png":  {"image/png", "png"},
+		"?format=jpeg": {"image/jpeg", "jpeg"},
+		"?format=JPG":  {"image/jpeg", "jpeg"},
+		"?format=bmp":  {"image/bmp", "bmp"},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/snapshot.png"+query, nil))
+		require.Equal(t, http.StatusOK, rec.Code, query)
+		assert.Equal(t, want.contentType, rec.Header().Get("Content-Type"), query)
+		img, format, err := image.Decode(rec.Body)
+		require.NoError(t, err, query)
+		assert.Equal(t, want.format, format, query)
+		assert.Equal(t, snap.Size, img.Bounds().Size(), query)
+		assert.Equal(t, color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}, color.RGBAModel.Convert(img.At(5, 5)), query)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/snapshot.png?format=gif", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"gif"`)
+}
+
+func TestHandleSVGSnapshot(t *testing.T) {
+	_, h := newTestServer(t)
+	postScript(t, h, "figure id=a 0.5 0.5\nupdate")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/snapshot.svg", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "image/svg+xml", rec.Header().Get("Content-Type"))
+	var doc struct {
+		XMLName xml.Name
+		Groups  []struct {
+			ID string `xml:"id,attr"`
+		} `xml:"g"`
+	}
+	require.NoError(t, xml.NewDecoder(rec.Body).Decode(&doc))
+	assert.Equal(t, "svg", doc.XMLName.Local)
+	require.Len(t, doc.Groups, 2)
+	assert.Equal(t, "a", doc.Groups[1].ID)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/snapshot.svg", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
@@ -0,0 +1,62 @@
+package painter
+
+import (
+	"context"
+	"errors"
+)
+
+var errNoTexture = errors.New("painter: no texture to draw on, operation skipped")
+
+// Ack is the completion handle of an operation posted with Loop.PostAck.
+type Ack struct {
+	done chan struct{}
+	err  error
+}
+
+func (a *Ack) resolve(err error) {
+	a.err = err
+	close(a.done)
+}
+
+// Done is closed once the operation has been applied and, if it requested
+// an update, the frame presented, or once it was dropped. A frame held back
+// by WithMaxFPS delays it; if the loop stops before that frame, Done is
+// closed without it.
+func (a *Ack) Done() <-chan struct{} {
+	return a.done
+}
+
+// Err returns the failure the operation reported, or the reason it was
+// dropped: ErrQueueFull or ErrStopped. It must only be called after Done is closed.
+func (a *Ack) Err() error {
+	return a.err
+}
+
+// Wait blocks until the operation is done or ctx ends and returns the
+// operation's failure or the context error.
+func (a *Ack) Wait(ctx context.Context) error {
+	select {
+	case <-a.done:
+		return a.err
+	default:
+	}
+	select {
+	case <-a.done:
+		return a.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ackedOperation carries an operation posted with PostAck through the
+// queue. The loop unwraps it, so operations are never seen wrapped. If ctx
+// is set and has ended by the time the loop gets to op, op is skipped.
+type ackedOperation struct {
+	op  Operation
+	ack *Ack
+	ctx context.Context
+}
+
+func (o ackedOperation) Do(state *LoopState) bool {
+	return o.op.Do(state)
+}
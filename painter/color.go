@@ -0,0 +1,205 @@
+package painter
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/colornames"
+)
+
+// ParseColor parses a CSS-like color: #rgb, #rgba, #rrggbb, #rrggbbaa,
+// rgb()/rgba(), hsl()/hsla() or a CSS named color. Whitespace inside the
+// functional forms is ignored.
+func ParseColor(s string) (color.NRGBA, error) {
+	spec := strings.ToLower(strings.Join(strings.Fields(s), ""))
+	switch {
+	case spec == "":
+		return color.NRGBA{}, errors.New("empty color")
+	case strings.HasPrefix(spec, "#"):
+		return parseHexColor(spec[1:])
+	case strings.HasPrefix(spec, "rgb(") || strings.HasPrefix(spec, "rgba("):
+		return parseRGBColor(spec)
+	case strings.HasPrefix(spec, "hsl(") || strings.HasPrefix(spec, "hsla("):
+		return parseHSLColor(spec)
+	case spec == "transparent":
+		return color.NRGBA{}, nil
+	case spec == "rebeccapurple":
+		return color.NRGBA{R: 0x66, G: 0x33, B: 0x99, A: 0xff}, nil
+	}
+	if c, ok := colornames.Map[spec]; ok {
+		return color.NRGBA{R: c.R, G: c.G, B: c.B, A: c.A}, nil
+	}
+	return color.NRGBA{}, fmt.Errorf("unknown color %q", s)
+}
+
+func parseHexColor(hex string) (color.NRGBA, error) {
+	switch len(hex) {
+	case 3, 4:
+		expanded := make([]byte, 0, 2*len(hex))
+		for i := 0; i < len(hex); i++ {
+			expanded = append(expanded, hex[i], hex[i])
+		}
+		hex = string(expanded)
+	case 6, 8:
+	default:
+		return color.NRGBA{}, fmt.Errorf("invalid hex color #%s: expected 3, 4, 6 or 8 digits", hex)
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("invalid hex color #%s", hex)
+	}
+	if len(hex) == 6 {
+		v = v<<8 | 0xff
+	}
+	return color.NRGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+}
+
+// functionArgs splits "name(a,b,c)" into its arguments and checks that the
+// count matches the function name: n for "name", n+1 for "namea".
+func functionArgs(spec, name string, n int) ([]string, bool, error) {
+	open := strings.IndexByte(spec, '(')
+	fn := spec[:open]
+	if !strings.HasSuffix(spec, ")") {
+		return nil, false, fmt.Errorf("invalid color %q: missing ')'", spec)
+	}
+	args := strings.Split(spec[open+1:len(spec)-1], ",")
+	withAlpha := len(args) == n+1
+	if len(args) != n && !withAlpha {
+		return nil, false, fmt.Errorf("invalid color %q: %s() expects %d or %d arguments", spec, name, n, n+1)
+	}
+	if fn == name+"a" && !withAlpha {
+		return nil, false, fmt.Errorf("invalid color %q: %sa() expects %d arguments", spec, name, n+1)
+	}
+	return args, withAlpha, nil
+}
+
+func parseNumber(arg string) (v float64, percent bool, err error) {
+	if strings.HasSuffix(arg, "%") {
+		arg, percent = strings.TrimSuffix(arg, "%"), true
+	}
+	v, err = strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, false, fmt.Errorf("invalid number %q", arg)
+	}
+	return v, percent, nil
+}
+
+func parseAlpha(arg string) (uint8, error) {
+	v, percent, err := parseNumber(arg)
+	if err != nil {
+		return 0, err
+	}
+	if percent {
+		v /= 100
+	}
+	return toByte(v), nil
+}
+
+func toByte(unit float64) uint8 {
+	return uint8(math.Round(math.Min(math.Max(unit, 0), 1) * 255))
+}
+
+func parseRGBColor(spec string) (color.NRGBA, error) {
+	args, withAlpha, err := functionArgs(spec, "rgb", 3)
+	if err != nil {
+		return color.NRGBA{}, err
+	}
+	var ch [3]uint8
+	for i := range ch {
+		v, percent, err := parseNumber(args[i])
+		if err != nil {
+			return color.NRGBA{}, fmt.Errorf("invalid color %q: %v", spec, err)
+		}
+		if percent {
+			v = v / 100 * 255
+		}
+		ch[i] = toByte(v / 255)
+	}
+	c := color.NRGBA{R: ch[0], G: ch[1], B: ch[2], A: 0xff}
+	if withAlpha {
+		if c.A, err = parseAlpha(args[3]); err != nil {
+			return color.NRGBA{}, fmt.Errorf("invalid color %q: %v", spec, err)
+		}
+	}
+	return c, nil
+}
+
+func parseHSLColor(spec string) (color.NRGBA, error) {
+	args, withAlpha, err := functionArgs(spec, "hsl", 3)
+	if err != nil {
+		return color.NRGBA{}, err
+	}
+	h, _, err := parseNumber(strings.TrimSuffix(args[0], "deg"))
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("invalid color %q: %v", spec, err)
+	}
+	var sl [2]float64
+	for i := range sl {
+		v, percent, err := parseNumber(args[i+1])
+		if err != nil || !percent {
+			return color.NRGBA{}, fmt.Errorf("invalid color %q: saturation and lightness must be percentages", spec)
+		}
+		sl[i] = math.Min(math.Max(v/100, 0), 1)
+	}
+	r, g, b := hslToRGB(math.Mod(math.Mod(h, 360)+360, 360)/360, sl[0], sl[1])
+	c := color.NRGBA{R: toByte(r), G: toByte(g), B: toByte(b), A: 0xff}
+	if withAlpha {
+		if c.A, err = parseAlpha(args[3]); err != nil {
+			return color.NRGBA{}, fmt.Errorf("invalid color %q: %v", spec, err)
+		}
+	}
+	return c, nil
+}
+
+func hslToRGB(h, s, l float64) (r, g, b float64) {
+	if s == 0 {
+		return l, l, l
+	}
+	var q float64
+	if l < 0.5 {
+		q = l * (1 + s)
+	} else {
+		q = l + s - l*s
+	}
+	p := 2*l - q
+	return hueToRGB(p, q, h+1.0/3), hueToRGB(p, q, h), hueToRGB(p, q, h-1.0/3)
+}
+
+func hueToRGB(p, q, t float64) float64 {
+	if t < 0 {
+		t++
+	}
+	if t > 1 {
+		t--
+	}
+	switch {
+	case t < 1.0/6:
+		return p + (q-p)*6*t
+	case t < 1.0/2:
+		return q
+	case t < 2.0/3:
+		return p + (q-p)*(2.0/3-t)*6
+	default:
+		return p
+	}
+}
+
+// WithOpacity scales the alpha of c by opacity, which is clamped to [0, 1].
+func WithOpacity(c color.Color, opacity float64) color.NRGBA {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	n.A = uint8(math.Round(float64(n.A) * math.Min(math.Max(opacity, 0), 1)))
+	return n
+}
+
+// HexColor formats c as #rrggbb, or #rrggbbaa when it is not opaque.
+func HexColor(c color.Color) string {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	if n.A == 0xff {
+		return fmt.Sprintf("#%02x%02x%02x", n.R, n.G, n.B)
+	}
+	return fmt.Sprintf("#%02x%02x%02x%02x", n.R, n.G, n.B, n.A)
+}
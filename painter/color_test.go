@@ -0,0 +1,47 @@
+package painter
+
+import (
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		in   string
+		want color.NRGBA
+	}{
+		{"#fff", color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}},
+		{"#f008", color.NRGBA{R: 0xff, A: 0x88}},
+		{"#00FF00", color.NRGBA{G: 0xff, A: 0xff}},
+		{"#01020380", color.NRGBA{R: 1, G: 2, B: 3, A: 0x80}},
+		{"rgb(255, 0, 0)", color.NRGBA{R: 0xff, A: 0xff}},
+		{"rgb(100%,50%,0%)", color.NRGBA{R: 0xff, G: 0x80, A: 0xff}},
+		{"rgba(0,0,255,0.5)", color.NRGBA{B: 0xff, A: 0x80}},
+		{"hsl(0, 100%, 50%)", color.NRGBA{R: 0xff, A: 0xff}},
+		{"hsl(240deg,100%,50%)", color.NRGBA{B: 0xff, A: 0xff}},
+		{"hsla(120, 100%, 25%, 50%)", color.NRGBA{G: 0x80, A: 0x80}},
+		{"hsl(0, 0%, 100%)", color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}},
+		{"navy", color.NRGBA{B: 0x80, A: 0xff}},
+		{"RebeccaPurple", color.NRGBA{R: 0x66, G: 0x33, B: 0x99, A: 0xff}},
+		{"transparent", color.NRGBA{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseColor(tt.in)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseColor_Invalid(t *testing.T) {
+	for _, in := range []string{"", "#12", "#12345", "#gggggg", "rgb(1,2)", "rgba(1,2,3)", "rgb(1,2,3", "hsl(0,100,50)", "rgb(a,b,c)", "notacolor"} {
+		t.Run(in, func(t *testing.T) {
+			_, err := ParseColor(in)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package painter
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Policy decides which frames an attached receiver gets when it cannot keep
+// up with the loop.
+type Policy struct {
+	every    bool
+	backlog  int
+	interval time.Duration
+}
+
+// DefaultFrameBacklog is the number of frames EveryFrame queues for a busy
+// receiver.
+const DefaultFrameBacklog = 16
+
+var (
+	// LatestFrame delivers the newest frame and skips the ones published
+	// while the receiver was busy.
+	LatestFrame = Policy{}
+	// EveryFrame delivers every frame in order, queuing up to
+	// DefaultFrameBacklog of them while the receiver is busy.
+	EveryFrame = Policy{every: true, backlog: DefaultFrameBacklog}
+)
+
+// Backlog delivers every frame in order like EveryFrame but queues at most
+// n of them. When the queue is full the oldest frame is dropped, so a stuck
+// receiver holds on to a bounded number of textures.
+func Backlog(n int) Policy {
+	return Policy{every: true, backlog: max(n, 1)}
+}
+
+// RateLimited delivers the newest frame at most once per interval. The last
+// frame is always delivered, at the latest one interval after it was
+// published.
+func RateLimited(interval time.Duration) Policy {
+	return Policy{interval: interval}
+}
+
+func (p Policy) String() string {
+	switch {
+	case p.every && p.backlog != DefaultFrameBacklog:
+		return fmt.Sprintf("every-frame(backlog %d)", p.backlog)
+	case p.every:
+		return "every-frame"
+	case p.interval > 0:
+		return "rate-limited(" + p.interval.String() + ")"
+	default:
+		return "latest-frame"
+	}
+}
+
+// subscription delivers frames to one receiver on its own goroutine, so a
+// slow receiver never holds up the loop or the other receivers.
+type subscription struct {
+	r      Receiver
+	policy Policy
+	clock  Clock
+
+	mu      sync.Mutex
+	pending []*Frame
+	wake    chan struct{}
+	done    chan struct{}
+}
+
+func (s *subscription) publish(f *Frame) {
+	s.mu.Lock()
+	switch {
+	case !s.policy.every:
+		s.drop()
+	case len(s.pending) >= s.policy.backlog:
+		log.Printf("Warning: Receiver %T is %d frames behind, dropping the oldest", s.r, len(s.pending))
+		s.pending[0].Release()
+		s.pending = append(s.pending[:0], s.pending[1:]...)
+	}
+	s.pending = append(s.pending, f)
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *subscription) take() (*Frame, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pending) == 0 {
+		return nil, false
+	}
+	f := s.pending[0]
+	s.pending = s.pending[1:]
+	return f, true
+}
+
+// drop releases the frames that were not delivered. s.mu must be held.
+func (s *subscription) drop() {
+	for _, f := range s.pending {
+		f.Release()
+	}
+	s.pending = s.pending[:0]
+}
+
+// stop ends delivery and releases the frames still pending.
+func (s *subscription) stop() {
+	close(s.done)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.drop()
+}
+
+func (s *subscription) run() {
+	var last time.Time
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.wake:
+		}
+		for {
+			if s.policy.interval > 0 && !last.IsZero() {
+				if wait := s.policy.interval - s.clock.Now().Sub(last); wait > 0 {
+					select {
+					case <-s.done:
+						return
+					case <-s.clock.After(wait):
+					}
+				}
+			}
+			f, ok := s.take()
+			if !ok {
+				break
+			}
+			s.r.Update(f)
+			last = s.clock.Now()
+		}
+	}
+}
+
+// fanout is the set of receivers attached to a loop.
+type fanout struct {
+	mu   sync.Mutex
+	subs []*subscription
+}
+
+// publish presents a frame made by swap to every receiver. Nothing is
+// swapped while no receivers are attached.
+func (f *fanout) publish(swap func(refs int) *Frame) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.subs) == 0 {
+		return
+	}
+	frame := swap(len(f.subs))
+	if frame == nil {
+		return
+	}
+	for _, s := range f.subs {
+		s.publish(frame)
+	}
+}
+
+func (f *fanout) remove(s *subscription) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, sub := range f.subs {
+		if sub == s {
+			f.subs = append(f.subs[:i:i], f.subs[i+1:]...)
+			s.stop()
+			return
+		}
+	}
+}
+
+func (f *fanout) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, s := range f.subs {
+		s.stop()
+	}
+	f.subs = nil
+}
+
+// Attach starts delivering every frame the loop presents to r according to
+// policy, on a goroutine of its own. Frames are passed as *Frame and r must
+// Release each one when it is done with it. It may be called at any time and
+// returns a function that detaches r again. Receivers are detached when the
+// loop stops.
+func (l *Loop) Attach(r Receiver, policy Policy) (detach func()) {
+	s := &subscription{
+		r:      r,
+		policy: policy,
+		clock:  l.clock,
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	l.fanout.mu.Lock()
+	l.fanout.subs = append(l.fanout.subs, s)
+	l.fanout.mu.Unlock()
+	go s.run()
+	log.Printf("Receiver %T attached (%s)", r, policy)
+	l.Post(UpdateOperation{})
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.fanout.remove(s)
+			log.Printf("Receiver %T detached", r)
+		})
+	}
+}
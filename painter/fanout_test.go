@@ -0,0 +1,137 @@
+package painter
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"golang.org/x/exp/shiny/screen"
+)
+
+// probe is a receiver that reports every frame on entered and, if release
+// is set, blocks until it is allowed to return. It releases the frame on
+// return.
+type probe struct {
+	entered chan struct{}
+	release chan struct{}
+	calls   atomic.Int32
+}
+
+func newProbe(blocking bool) *probe {
+	p := &probe{entered: make(chan struct{}, 100)}
+	if blocking {
+		p.release = make(chan struct{})
+	}
+	return p
+}
+
+func (p *probe) Update(t screen.Texture) {
+	defer t.(*Frame).Release()
+	p.calls.Add(1)
+	p.entered <- struct{}{}
+	if p.release != nil {
+		<-p.release
+	}
+}
+
+func updates(t *testing.T, l *Loop, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		l.Post(UpdateOperation{})
+	}
+	require.NoError(t, l.Drain(t.Context()))
+}
+
+func TestLoop_AttachPolicies(t *testing.T) {
+	for _, tc := range []struct {
+		policy Policy
+		calls  int32
+	}{
+		{LatestFrame, 2},
+		{EveryFrame, 6},
+	} {
+		t.Run(tc.policy.String(), func(t *testing.T) {
+			l := newTestLoop(t)
+			p := newProbe(true)
+			detach := l.Attach(p, tc.policy)
+			defer detach()
+
+			updates(t, l, 0) // the update queued by Attach
+			<-p.entered
+			updates(t, l, 5) // does not wait for the blocked receiver
+
+			for i := int32(1); i < tc.calls; i++ {
+				p.release <- struct{}{}
+				<-p.entered
+			}
+			p.release <- struct{}{}
+			assert.Never(t, func() bool { return p.calls.Load() > tc.calls }, 50*time.Millisecond, 5*time.Millisecond)
+			assert.Equal(t, tc.calls, p.calls.Load())
+		})
+	}
+}
+
+func TestLoop_AttachRateLimited(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	l := newTestLoop(t, WithClock(clock))
+	p := newProbe(false)
+	detach := l.Attach(p, RateLimited(time.Second))
+	defer detach()
+
+	updates(t, l, 0)
+	<-p.entered
+	updates(t, l, 3)
+	require.Eventually(t, func() bool { return clock.Waiters() == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, int32(1), p.calls.Load(), "frames wait for the interval")
+
+	clock.Advance(time.Second)
+	<-p.entered
+	assert.Equal(t, int32(2), p.calls.Load(), "the frames are coalesced into one")
+}
+
+func TestLoop_Detach(t *testing.T) {
+	l := newTestLoop(t)
+	kept, dropped := newProbe(false), newProbe(false)
+	defer l.Attach(kept, EveryFrame)()
+	detach := l.Attach(dropped, EveryFrame)
+	updates(t, l, 0)
+	<-kept.entered
+	<-kept.entered
+	<-dropped.entered
+	<-dropped.entered
+
+	detach()
+	detach()
+	updates(t, l, 1)
+	<-kept.entered
+	assert.Never(t, func() bool { return dropped.calls.Load() > 2 }, 50*time.Millisecond, 5*time.Millisecond)
+}
+
+func TestLoop_AttachBacklog(t *testing.T) {
+	s := new(countingScreen)
+	l := NewLoop(s)
+	p := newProbe(true)
+	detach := l.Attach(p, Backlog(2))
+	defer detach()
+
+	updates(t, l, 0)
+	<-p.entered
+	updates(t, l, 10)
+	// The held frame, the backlog, the frame being published and the back
+	// texture.
+	allocated := s.allocated.Load()
+	assert.LessOrEqual(t, allocated, int32(5))
+	updates(t, l, 20)
+	assert.Equal(t, allocated, s.allocated.Load(), "a stuck receiver does not cost a texture per frame")
+
+	for i := 0; i < 2; i++ {
+		p.release <- struct{}{}
+		<-p.entered
+	}
+	p.release <- struct{}{}
+	assert.Never(t, func() bool { return p.calls.Load() > 3 }, 50*time.Millisecond, 5*time.Millisecond)
+	assert.Equal(t, "every-frame(backlog 2)", Backlog(2).String())
+}
@@ -0,0 +1,79 @@
+// Package headless provides an in-memory screen.Screen for running the
+// painter without a display. Textures and buffers are backed by image.RGBA.
+package headless
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"sync"
+
+	"golang.org/x/exp/shiny/screen"
+)
+
+var ErrNoWindow = errors.New("headless: windows are not supported")
+
+type Screen struct{}
+
+func NewScreen() *Screen {
+	return &Screen{}
+}
+
+func (s *Screen) NewBuffer(size image.Point) (screen.Buffer, error) {
+	return &Buffer{rgba: image.NewRGBA(image.Rectangle{Max: size})}, nil
+}
+
+func (s *Screen) NewTexture(size image.Point) (screen.Texture, error) {
+	return NewTexture(size), nil
+}
+
+func (s *Screen) NewWindow(_ *screen.NewWindowOptions) (screen.Window, error) {
+	return nil, ErrNoWindow
+}
+
+type Buffer struct {
+	rgba *image.RGBA
+}
+
+func (b *Buffer) Release()                {}
+func (b *Buffer) Size() image.Point       { return b.rgba.Rect.Size() }
+func (b *Buffer) Bounds() image.Rectangle { return b.rgba.Rect }
+func (b *Buffer) RGBA() *image.RGBA       { return b.rgba }
+
+// Texture is safe for concurrent use, so a frame can be copied out while the
+// painter loop keeps drawing.
+type Texture struct {
+	mu   sync.Mutex
+	rgba *image.RGBA
+}
+
+func NewTexture(size image.Point) *Texture {
+	return &Texture{rgba: image.NewRGBA(image.Rectangle{Max: size})}
+}
+
+func (t *Texture) Release()                {}
+func (t *Texture) Size() image.Point       { return t.rgba.Rect.Size() }
+func (t *Texture) Bounds() image.Rectangle { return t.rgba.Rect }
+
+func (t *Texture) Upload(dp image.Point, src screen.Buffer, sr image.Rectangle) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	dr := sr.Sub(sr.Min).Add(dp)
+	draw.Draw(t.rgba, dr, src.RGBA(), sr.Min, draw.Src)
+}
+
+func (t *Texture) Fill(dr image.Rectangle, src color.Color, op draw.Op) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	draw.Draw(t.rgba, dr, image.NewUniform(src), image.Point{}, op)
+}
+
+// Image returns a copy of the current texture content.
+func (t *Texture) Image() *image.RGBA {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	img := image.NewRGBA(t.rgba.Rect)
+	copy(img.Pix, t.rgba.Pix)
+	return img
+}
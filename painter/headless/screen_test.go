@@ -0,0 +1,67 @@
+package headless
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gothicenemy/software-architecture-3/painter"
+
+	"golang.org/x/exp/shiny/screen"
+)
+
+func TestTexture_Fill(t *testing.T) {
+	tex := NewTexture(image.Pt(10, 10))
+	assert.Equal(t, image.Rect(0, 0, 10, 10), tex.Bounds())
+
+	tex.Fill(tex.Bounds(), color.White, draw.Src)
+	tex.Fill(image.Rect(0, 0, 5, 5), color.NRGBA{R: 0xff, A: 0x80}, draw.Over)
+	tex.Fill(image.Rect(5, 5, 10, 10), color.Transparent, draw.Src)
+
+	img := tex.Image()
+	assert.Equal(t, color.RGBA{R: 0xff, G: 0x7f, B: 0x7f, A: 0xff}, img.RGBAAt(1, 1))
+	assert.Equal(t, color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}, img.RGBAAt(7, 1))
+	assert.Equal(t, color.RGBA{}, img.RGBAAt(7, 7))
+
+	img.SetRGBA(7, 1, color.RGBA{})
+	assert.Equal(t, color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}, tex.Image().RGBAAt(7, 1), "Image returns a copy")
+}
+
+func TestTexture_Upload(t *testing.T) {
+	s := NewScreen()
+	buf, err := s.NewBuffer(image.Pt(4, 4))
+	require.NoError(t, err)
+	buf.RGBA().SetRGBA(1, 1, color.RGBA{B: 0xff, A: 0xff})
+
+	tex, err := s.NewTexture(image.Pt(8, 8))
+	require.NoError(t, err)
+	tex.Upload(image.Pt(4, 4), buf, image.Rect(1, 1, 3, 3))
+	assert.Equal(t, color.RGBA{B: 0xff, A: 0xff}, tex.(*Texture).Image().RGBAAt(4, 4))
+
+	_, err = s.NewWindow(nil)
+	assert.ErrorIs(t, err, ErrNoWindow)
+}
+
+type textureReceiver chan screen.Texture
+
+func (r textureReceiver) Update(t screen.Texture) { r <- t }
+
+func TestScreen_DrivesPainterLoop(t *testing.T) {
+	l := painter.NewLoop(NewScreen())
+	received := make(textureReceiver, 1)
+	l.SetReceiver(received)
+	go l.Start()
+	defer l.Stop()
+
+	tex, ok := (<-received).(*Texture)
+	require.True(t, ok)
+
+	img := tex.Image()
+	assert.Equal(t, image.Rect(0, 0, 800, 800), img.Bounds())
+	assert.Equal(t, color.RGBA{G: 0xff, A: 0xff}, img.RGBAAt(0, 0))
+	assert.Equal(t, color.RGBA{R: 0xff, G: 0xff, A: 0xff}, img.RGBAAt(400, 400))
+}
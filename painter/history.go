@@ -0,0 +1,156 @@
+package painter
+
+import (
+	"errors"
+	"log"
+	"slices"
+)
+
+// DefaultHistoryDepth is the number of undo steps kept by a new loop.
+const DefaultHistoryDepth = 100
+
+var (
+	// ErrNothingToUndo is the failure of an undo with no change to undo.
+	ErrNothingToUndo = errors.New("painter: nothing to undo")
+	// ErrNothingToRedo is the failure of a redo with no undone change.
+	ErrNothingToRedo = errors.New("painter: nothing to redo")
+)
+
+// History keeps the scenes that undo and redo return to. The loop records
+// an undo step for every operation that changed the scene.
+type History struct {
+	// Depth is the maximum number of undo steps. Zero disables the history.
+	Depth int
+	undo  []step
+	redo  []Scene
+}
+
+// step puts the scene back as it was before one change. Most changes keep a
+// copy of the scene; adding, moving and shifting figures keep only what they
+// change, so scripts of many small changes do not copy the scene every time.
+// Steps are undone last first, so a step always finds the scene as its
+// change left it.
+type step interface {
+	undo(state *LoopState)
+}
+
+// sceneStep returns to a copy of the scene.
+type sceneStep Scene
+
+func (s sceneStep) undo(state *LoopState) {
+	state.SetScene(Scene(s))
+}
+
+// appendStep removes the figures and background rectangles added since
+// their counts were taken.
+type appendStep struct {
+	figures, bgRects int
+}
+
+func (s appendStep) undo(state *LoopState) {
+	state.Figures = state.Figures[:s.figures]
+	state.BgRects = state.BgRects[:s.bgRects]
+	state.Changed()
+}
+
+// placesStep puts figures back where they were.
+type placesStep []place
+
+// place is the position of the figure at index i.
+type place struct {
+	i    int
+	x, y float64
+}
+
+func (s placesStep) undo(state *LoopState) {
+	for _, p := range s {
+		state.Figures[p.i].X, state.Figures[p.i].Y = p.x, p.y
+	}
+	state.Changed()
+}
+
+// placesOf returns the positions of the figures t selects.
+func placesOf(state *LoopState, t Target) placesStep {
+	var s placesStep
+	for i, f := range state.Figures {
+		if t.Matches(f) {
+			s = append(s, place{i: i, x: f.X, y: f.Y})
+		}
+	}
+	return s
+}
+
+func NewHistory(depth int) *History {
+	return &History{Depth: depth}
+}
+
+// CanUndo reports whether there is a change to undo.
+func (h *History) CanUndo() bool { return len(h.undo) > 0 }
+
+// CanRedo reports whether there is an undone change to reapply.
+func (h *History) CanRedo() bool { return len(h.redo) > 0 }
+
+// Push records before as the scene to return to on undo and forgets the
+// changes that were undone.
+func (h *History) Push(before Scene) {
+	h.push(sceneStep(before))
+}
+
+func (h *History) push(s step) {
+	h.redo = nil
+	if h.Depth <= 0 {
+		h.undo = nil
+		return
+	}
+	h.undo = append(h.undo, s)
+	if extra := len(h.undo) - h.Depth; extra > 0 {
+		h.undo = append([]step(nil), h.undo[extra:]...)
+	}
+}
+
+// clone copies the stacks so that h can be put back as it was.
+func (h *History) clone() History {
+	return History{Depth: h.Depth, undo: slices.Clone(h.undo), redo: slices.Clone(h.redo)}
+}
+
+// record pushes s, the step that undoes op. Undo and redo manage the stacks
+// themselves.
+func (h *History) record(op Operation, s step) {
+	switch op.(type) {
+	case UndoOperation, RedoOperation:
+		return
+	}
+	h.push(s)
+}
+
+// UndoOperation restores the scene as it was before the last change.
+type UndoOperation struct{}
+
+func (o UndoOperation) Do(state *LoopState) bool {
+	h := state.History
+	if h == nil || !h.CanUndo() {
+		state.Fail(ErrNothingToUndo)
+		return false
+	}
+	h.redo = append(h.redo, state.Scene())
+	h.undo[len(h.undo)-1].undo(state)
+	h.undo = h.undo[:len(h.undo)-1]
+	log.Printf("Undone, %d steps left", len(h.undo))
+	return false
+}
+
+// RedoOperation reapplies the last undone change.
+type RedoOperation struct{}
+
+func (o RedoOperation) Do(state *LoopState) bool {
+	h := state.History
+	if h == nil || !h.CanRedo() {
+		state.Fail(ErrNothingToRedo)
+		return false
+	}
+	h.undo = append(h.undo, sceneStep(state.Scene()))
+	state.SetScene(h.redo[len(h.redo)-1])
+	h.redo = h.redo[:len(h.redo)-1]
+	log.Printf("Redone, %d steps left", len(h.redo))
+	return false
+}
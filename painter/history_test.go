@@ -0,0 +1,114 @@
+package painter
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoop_UndoRedo(t *testing.T) {
+	l := newTestLoop(t)
+	// apply steps through ops and returns the resulting scene.
+	apply := func(ops ...Operation) Scene {
+		for _, op := range ops {
+			l.Post(op)
+		}
+		require.NoError(t, l.Drain(t.Context()))
+		return l.state.Scene()
+	}
+
+	initial := apply()
+	changed := apply(
+		WhiteOperation{},
+		UpdateOperation{},
+		ResizeOperation{Size: image.Pt(800, 800)},
+		FigureOperation{X: 0.2, Y: 0.2, ID: "a"},
+	)
+
+	afterOne := apply(UndoOperation{})
+	assert.Equal(t, color.White, afterOne.Background)
+	assert.Len(t, afterOne.Figures, 1, "the figure is undone first")
+
+	assert.Equal(t, initial, apply(UndoOperation{}, UndoOperation{}), "updates and resizes are not undo steps")
+	assert.Equal(t, changed, apply(RedoOperation{}, RedoOperation{}))
+
+	sc := apply(UndoOperation{}, GreenOperation{}, RedoOperation{})
+	assert.Equal(t, color.RGBA{G: 0xff, A: 0xff}, sc.Background)
+	assert.Len(t, sc.Figures, 1, "a new change drops the redo steps")
+}
+
+func TestHistory_Depth(t *testing.T) {
+	state := &LoopState{Background: color.Black, History: NewHistory(2)}
+	for i := 0; i < 4; i++ {
+		before := state.Scene()
+		op := FigureOperation{X: float64(i) / 4}
+		op.Do(state)
+		state.History.record(op, sceneStep(before))
+	}
+
+	UndoOperation{}.Do(state)
+	UndoOperation{}.Do(state)
+	assert.False(t, state.History.CanUndo())
+	assert.Len(t, state.Figures, 2)
+	UndoOperation{}.Do(state)
+	assert.Len(t, state.Figures, 2)
+
+	state.History.Depth = 0
+	before := state.Scene()
+	WhiteOperation{}.Do(state)
+	state.History.record(WhiteOperation{}, sceneStep(before))
+	assert.False(t, state.History.CanUndo())
+	assert.False(t, state.History.CanRedo())
+}
+
+func TestLoop_RolledBackBatchKeepsHistory(t *testing.T) {
+	l := newTestLoop(t)
+	l.Post(FigureOperation{X: 0.2, Y: 0.2, ID: "a"})
+	l.Post(WhiteOperation{})
+	batch := l.PostAck(BatchOperation{Ops: []Operation{
+		UndoOperation{},
+		FigureOperation{X: 0.3, Y: 0.3, ID: "a"},
+	}})
+	require.NoError(t, l.Drain(t.Context()))
+	require.Error(t, batch.Err())
+	assert.Equal(t, color.White, l.state.Background, "the undo was rolled back")
+	assert.False(t, l.state.History.CanRedo())
+
+	l.Post(UndoOperation{})
+	require.NoError(t, l.Drain(t.Context()))
+	assert.Equal(t, color.RGBA{G: 0xff, A: 0xff}, l.state.Background, "undo still returns to the scene before white")
+	assert.Len(t, l.state.Figures, 2)
+}
+
+func TestLoop_UndoCheapSteps(t *testing.T) {
+	l := newTestLoop(t)
+	var scenes []Scene
+	for _, op := range []Operation{
+		FigureOperation{X: 0.1, Y: 0.1, ID: "a", Tags: []string{"t"}},
+		BgRectOperation{X1: 0.1, Y1: 0.1, X2: 0.5, Y2: 0.5},
+		MoveOperation{X: 0.3, Y: 0.3, Target: Target{ID: "a"}},
+		ShiftOperation{DX: 0.1, DY: -0.1},
+		WhiteOperation{},
+		FigureOperation{X: 0.9, Y: 0.9, ID: "b"},
+		MoveOperation{X: 0.5, Y: 0.5, KeepLayout: true},
+	} {
+		scenes = append(scenes, l.state.Scene())
+		l.Post(op)
+		require.NoError(t, l.Drain(t.Context()))
+	}
+	last := l.state.Scene()
+
+	for i := len(scenes) - 1; i >= 0; i-- {
+		l.Post(UndoOperation{})
+		require.NoError(t, l.Drain(t.Context()))
+		assert.Equal(t, scenes[i], l.state.Scene(), "undo %d", len(scenes)-i)
+	}
+	for range scenes {
+		l.Post(RedoOperation{})
+	}
+	require.NoError(t, l.Drain(t.Context()))
+	assert.Equal(t, last, l.state.Scene())
+}
@@ -0,0 +1,89 @@
+package painter
+
+import (
+	"fmt"
+	"time"
+)
+
+// Interceptor wraps the application of every operation the loop processes.
+// Interceptors run on the loop goroutine in the order they were added; their
+// After hooks run in reverse order. A BatchOperation is intercepted as a
+// whole and then each of its operations again, so a veto inside a batch
+// rolls the whole batch back. Reads made with Loop.Read and Loop.Snapshot
+// are not operations and bypass the interceptors.
+type Interceptor interface {
+	// Before is called before op is applied. It returns the operation to
+	// apply instead, usually op itself, or an error to veto it. A vetoed
+	// operation fails with that error and no After hooks run for it.
+	Before(op Operation, state *LoopState) (Operation, error)
+	// After is called once the operation returned by Before has been
+	// applied, with the time Do took.
+	After(op Operation, state *LoopState, d time.Duration)
+}
+
+// Hooks adapts a pair of functions to the Interceptor interface. Either may
+// be nil.
+type Hooks struct {
+	BeforeFunc func(op Operation, state *LoopState) (Operation, error)
+	AfterFunc  func(op Operation, state *LoopState, d time.Duration)
+}
+
+func (h Hooks) Before(op Operation, state *LoopState) (Operation, error) {
+	if h.BeforeFunc == nil {
+		return op, nil
+	}
+	return h.BeforeFunc(op, state)
+}
+
+func (h Hooks) After(op Operation, state *LoopState, d time.Duration) {
+	if h.AfterFunc != nil {
+		h.AfterFunc(op, state, d)
+	}
+}
+
+// VetoError is the failure of an operation rejected by an interceptor.
+type VetoError struct {
+	Op  Operation
+	Err error
+}
+
+func (e *VetoError) Error() string {
+	return fmt.Sprintf("operation %T rejected: %v", e.Op, e.Err)
+}
+
+func (e *VetoError) Unwrap() error { return e.Err }
+
+// Use adds interceptors to the loop. It must be called before Start.
+func (l *Loop) Use(interceptors ...Interceptor) {
+	l.interceptors = append(l.interceptors, interceptors...)
+}
+
+// intercept applies op through the interceptor chain and returns the
+// operation that was applied and its update request. It reports false if
+// the operation was vetoed. prepare, if set, is called with the operation
+// the interceptors settled on right before it is applied.
+func (l *Loop) intercept(op Operation, prepare func(op Operation)) (Operation, bool, bool) {
+	for _, i := range l.interceptors {
+		next, err := i.Before(op, l.state)
+		if err != nil {
+			l.state.Fail(&VetoError{Op: op, Err: err})
+			return op, false, false
+		}
+		if next == nil {
+			next = op
+		}
+		op = next
+	}
+
+	if prepare != nil {
+		prepare(op)
+	}
+	start := l.clock.Now()
+	updateRequested := op.Do(l.state)
+	d := l.clock.Now().Sub(start)
+
+	for i := len(l.interceptors) - 1; i >= 0; i-- {
+		l.interceptors[i].After(op, l.state, d)
+	}
+	return op, updateRequested, true
+}
@@ -0,0 +1,126 @@
+package painter
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoop_Interceptors(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	l := newTestLoop(t, WithClock(clock))
+
+	var calls []string
+	trace := func(name string) Interceptor {
+		return Hooks{
+			BeforeFunc: func(op Operation, _ *LoopState) (Operation, error) {
+				calls = append(calls, name+" before")
+				return op, nil
+			},
+			AfterFunc: func(op Operation, _ *LoopState, d time.Duration) {
+				calls = append(calls, name+" after "+d.String())
+			},
+		}
+	}
+	readOnly := errors.New("background is read-only")
+	l.Use(
+		trace("outer"),
+		Hooks{BeforeFunc: func(op Operation, state *LoopState) (Operation, error) {
+			switch op := op.(type) {
+			case WhiteOperation, GreenOperation, BackgroundOperation:
+				return nil, readOnly
+			case MoveOperation:
+				// Keep figures on the canvas.
+				op.X, op.Y = math.Min(op.X, 0.9), math.Min(op.Y, 0.9)
+				return op, nil
+			}
+			return op, nil
+		}},
+		trace("inner"),
+	)
+
+	move := l.PostAck(MoveOperation{X: 1, Y: 0.5})
+	white := l.PostAck(WhiteOperation{})
+	l.Post(OperationFunc(func(*LoopState) bool {
+		clock.Advance(2 * time.Second)
+		return false
+	}))
+	require.NoError(t, l.Drain(t.Context()))
+
+	require.NoError(t, move.Err())
+	assert.Equal(t, 0.9, l.state.Figures[0].X, "the move was rewritten")
+
+	var veto *VetoError
+	require.ErrorAs(t, white.Err(), &veto)
+	assert.ErrorIs(t, white.Err(), readOnly)
+	assert.Equal(t, color.RGBA{G: 0xff, A: 0xff}, l.state.Background, "the vetoed operation was not applied")
+
+	assert.Equal(t, []string{
+		"outer before", "inner before", "inner after 0s", "outer after 0s",
+		"outer before",
+		"outer before", "inner before", "inner after 2s", "outer after 2s",
+	}, calls)
+}
+
+func TestLoop_InterceptorsSeeBatchedOperations(t *testing.T) {
+	l := newTestLoop(t)
+	readOnly := errors.New("background is read-only")
+	var seen []string
+	l.Use(Hooks{BeforeFunc: func(op Operation, _ *LoopState) (Operation, error) {
+		seen = append(seen, fmt.Sprintf("%T", op))
+		switch op := op.(type) {
+		case WhiteOperation:
+			return nil, readOnly
+		case MoveOperation:
+			op.X = math.Min(op.X, 0.9)
+			return op, nil
+		}
+		return op, nil
+	}})
+
+	vetoed := l.PostAck(BatchOperation{Ops: []Operation{FigureOperation{X: 0.1, Y: 0.1}, WhiteOperation{}}})
+	moved := l.PostAck(BatchOperation{Ops: []Operation{MoveOperation{X: 1, Y: 0.5}}})
+	require.NoError(t, l.Drain(t.Context()))
+
+	var veto *VetoError
+	require.ErrorAs(t, vetoed.Err(), &veto, "a veto inside a batch fails the batch")
+	assert.ErrorIs(t, vetoed.Err(), readOnly)
+	assert.Len(t, l.state.Figures, 1, "the batch was rolled back")
+	assert.Equal(t, color.RGBA{G: 0xff, A: 0xff}, l.state.Background)
+
+	require.NoError(t, moved.Err())
+	assert.Equal(t, 0.9, l.state.Figures[0].X, "operations inside a batch are rewritten")
+	assert.Equal(t, []string{
+		"painter.BatchOperation", "painter.FigureOperation", "painter.WhiteOperation",
+		"painter.BatchOperation", "painter.MoveOperation",
+	}, seen)
+}
+
+func TestLoop_ReadsBypassInterceptors(t *testing.T) {
+	l := newTestLoop(t)
+	var seen []Operation
+	l.Use(Hooks{BeforeFunc: func(op Operation, _ *LoopState) (Operation, error) {
+		seen = append(seen, op)
+		if _, ok := op.(UpdateOperation); !ok {
+			return nil, errors.New("unknown operation")
+		}
+		return op, nil
+	}})
+	go l.Start()
+	defer l.Stop()
+
+	snap, err := l.Snapshot(t.Context())
+	require.NoError(t, err)
+	assert.Len(t, snap.Figures, 1)
+	var bg color.Color
+	require.NoError(t, l.Read(t.Context(), func(state *LoopState) { bg = state.Background }))
+	assert.Equal(t, color.RGBA{G: 0xff, A: 0xff}, bg)
+	require.NoError(t, l.PostAck(UpdateOperation{}).Wait(t.Context()))
+	assert.Equal(t, []Operation{UpdateOperation{}}, seen, "interceptors only see operations")
+}
@@ -0,0 +1,173 @@
+// Package journal persists the operations applied by a painter loop so that
+// the scene survives a crash or a restart.
+//
+// A journal is a script in the painter command language. Every operation
+// that changes the scene is appended to it as one line. The file is
+// compacted into a snapshot (a reset followed by the commands that rebuild
+// the current scene) when it is opened, every CompactEvery appended lines,
+// and whenever an operation that has no textual form, such as load, changes
+// the scene.
+package journal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gothicenemy/software-architecture-3/painter"
+	"github.com/gothicenemy/software-architecture-3/painter/lang"
+)
+
+// DefaultCompactEvery is the number of appended lines after which the
+// journal is compacted when no other value is given.
+const DefaultCompactEvery = 1000
+
+type Journal struct {
+	path         string
+	compactEvery int
+	file         *os.File
+	appended     int
+}
+
+// Restore replays the journal at path onto loop, compacts it and installs
+// itself as the loop's recorder. It must be called before loop.Start. A
+// missing file is not an error: the journal starts from the loop's current
+// scene.
+func Restore(loop *painter.Loop, path string, compactEvery int) (*Journal, error) {
+	if compactEvery <= 0 {
+		compactEvery = DefaultCompactEvery
+	}
+	ops, err := read(path)
+	if err != nil {
+		return nil, err
+	}
+	sc := loop.Apply(ops)
+	log.Printf("Journal %s replayed: %d operations", path, len(ops))
+
+	j := &Journal{path: path, compactEvery: compactEvery}
+	if err := j.compact(sc); err != nil {
+		return nil, err
+	}
+	loop.Recorder = j
+	return j, nil
+}
+
+func read(path string) ([]painter.Operation, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Every line is written with its newline in one write, so a last line
+	// without one was cut short by a crash. It is dropped: a cut line may
+	// still parse, with a shorter number or colour than was written.
+	if i := bytes.LastIndexByte(data, '\n'); i < len(data)-1 {
+		log.Printf("Journal %s: dropping unfinished last line %q", path, data[i+1:])
+		data = data[:i+1]
+	}
+	// Lines damaged otherwise show up as diagnostics and are skipped.
+	parser := &lang.Parser{Mode: lang.Lenient, Exact: true}
+	ops, diags, err := parser.Parse(bytes.NewReader(data))
+	for _, d := range diags {
+		log.Printf("Journal %s:%s", path, d)
+	}
+	return ops, err
+}
+
+// Record implements painter.Recorder. The loop calls it only for operations
+// that changed the scene.
+func (j *Journal) Record(op painter.Operation, state *painter.LoopState) {
+	var err error
+	if cmd, ok := op.(fmt.Stringer); ok && j.file != nil && j.appended < j.compactEvery {
+		err = j.append(cmd.String())
+	} else {
+		err = j.compact(state.Scene())
+	}
+	if err != nil {
+		log.Printf("Error: Cannot write journal: %v", err)
+	}
+}
+
+func (j *Journal) append(line string) error {
+	if _, err := j.file.WriteString(line + "\n"); err != nil {
+		return err
+	}
+	j.appended++
+	return nil
+}
+
+// compact atomically replaces the journal with a snapshot of sc.
+func (j *Journal) compact(sc painter.Scene) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# painter journal, compacted %s\n", time.Now().Format(time.RFC3339))
+	for _, op := range snapshot(sc) {
+		b.WriteString(op.String() + "\n")
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(j.path), ".journal-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(b.String()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if j.file != nil {
+		j.file.Close()
+		j.file = nil
+	}
+	if err := os.Rename(tmp.Name(), j.path); err != nil {
+		return err
+	}
+	if j.file, err = os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0o644); err != nil {
+		return err
+	}
+	j.appended = 0
+	return nil
+}
+
+// snapshot returns the operations that rebuild sc from any state.
+func snapshot(sc painter.Scene) []fmt.Stringer {
+	ops := []fmt.Stringer{
+		painter.ResetOperation{},
+		painter.BackgroundOperation{Color: sc.Background},
+	}
+	for _, r := range sc.BgRects {
+		ops = append(ops, painter.BgRectOperation{X1: r.X1, Y1: r.Y1, X2: r.X2, Y2: r.Y2, Color: r.Color})
+	}
+	for _, f := range sc.Figures {
+		ops = append(ops, painter.FigureOperation{X: f.X, Y: f.Y, ID: f.ID, Tags: f.Tags})
+	}
+	return ops
+}
+
+// Close flushes the journal to disk. It must be called after the loop has
+// stopped.
+func (j *Journal) Close() error {
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Sync()
+	if cerr := j.file.Close(); err == nil {
+		err = cerr
+	}
+	j.file = nil
+	return err
+}
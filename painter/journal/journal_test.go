@@ -0,0 +1,133 @@
+package journal
+
+import (
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gothicenemy/software-architecture-3/painter"
+	"github.com/gothicenemy/software-architecture-3/painter/headless"
+)
+
+// apply posts ops and returns the state once the loop has applied them.
+func apply(t *testing.T, l *painter.Loop, ops ...painter.Operation) painter.Snapshot {
+	t.Helper()
+	for _, op := range ops {
+		require.NoError(t, l.PostContext(t.Context(), op))
+	}
+	snap, err := l.Snapshot(t.Context())
+	require.NoError(t, err)
+	return snap
+}
+
+// restore starts a loop with the journal at path replayed.
+func restore(t *testing.T, path string, compactEvery int) (*painter.Loop, *Journal) {
+	t.Helper()
+	l := painter.NewLoop(headless.NewScreen())
+	j, err := Restore(l, path, compactEvery)
+	require.NoError(t, err)
+	go l.Start()
+	t.Cleanup(l.Stop)
+	return l, j
+}
+
+func journalLines(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func TestJournal_RestoresScene(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "painter.journal")
+
+	l, j := restore(t, path, 0)
+	want := apply(t, l,
+		painter.ResetOperation{},
+		painter.BackgroundOperation{Color: color.NRGBA{R: 0x12, G: 0x34, B: 0x56, A: 0xff}},
+		painter.BgRectOperation{X1: 0.1, Y1: 0.1, X2: 0.6, Y2: 0.4, Color: color.NRGBA{B: 0xff, A: 0x80}},
+		painter.FigureOperation{X: 0.3, Y: 0.3, ID: "a", Tags: []string{"red"}},
+		painter.FigureOperation{X: 0.7, Y: 0.7},
+		painter.ShiftOperation{DX: 0.9, DY: -0.1, Target: painter.Target{ID: "a"}},
+		painter.UpdateOperation{},
+	)
+	assert.InDelta(t, 1.2, want.Figures[0].X, 1e-9, "the figure is off the canvas")
+	// The journal is not closed, as after a crash.
+
+	l2, j2 := restore(t, path, 0)
+	defer j2.Close()
+	assert.Equal(t, want, apply(t, l2))
+	require.NoError(t, j.Close())
+}
+
+func TestJournal_SkipsUnchangedScene(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "painter.journal")
+	l, j := restore(t, path, 0)
+	defer j.Close()
+	before := len(journalLines(t, path))
+
+	apply(t, l, painter.UpdateOperation{}, painter.MoveOperation{X: 0.5, Y: 0.5, Target: painter.Target{ID: "missing"}})
+	assert.Len(t, journalLines(t, path), before)
+
+	apply(t, l, painter.WhiteOperation{})
+	lines := journalLines(t, path)
+	assert.Len(t, lines, before+1)
+	assert.Equal(t, "white", lines[len(lines)-1])
+}
+
+func TestJournal_Compacts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "painter.journal")
+	l, j := restore(t, path, 3)
+	defer j.Close()
+
+	for i := 0; i < 4; i++ {
+		apply(t, l, painter.ShiftOperation{DX: 0.125})
+	}
+	lines := journalLines(t, path)
+	assert.Equal(t, "reset", lines[1], "fourth change compacts the journal")
+	assert.Equal(t, "figure 1 0.5", lines[len(lines)-1])
+
+	apply(t, l, painter.OperationFunc(func(state *painter.LoopState) bool {
+		state.Figures[0].Y = 0.25
+		state.Changed()
+		return false
+	}))
+	lines = journalLines(t, path)
+	assert.Equal(t, "figure 1 0.25", lines[len(lines)-1], "operations without text compact the journal")
+}
+
+func TestJournal_SkipsDamagedLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "painter.journal")
+	require.NoError(t, os.WriteFile(path, []byte("reset\nbg #ffffff\nfigure id=a 0.2 0.2\nmove id=a 0.\n"), 0o644))
+
+	l, j := restore(t, path, 0)
+	defer j.Close()
+	sc := apply(t, l)
+	require.Len(t, sc.Figures, 1)
+	assert.Equal(t, 0.2, sc.Figures[0].X)
+	assert.Equal(t, []string{"bg #ffffff", "figure id=a 0.2 0.2"}, journalLines(t, path)[2:])
+}
+
+func TestJournal_DropsUnfinishedLastLine(t *testing.T) {
+	for name, tail := range map[string]string{
+		"number": "figure id=b 0.2 0.2",
+		"colour": "bg #ff0",
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "painter.journal")
+			require.NoError(t, os.WriteFile(path, []byte("reset\nfigure id=a 0.5 0.5\n"+tail), 0o644))
+
+			l, j := restore(t, path, 0)
+			defer j.Close()
+			sc := apply(t, l)
+			require.Len(t, sc.Figures, 1, "the cut line is not replayed")
+			assert.Equal(t, "a", sc.Figures[0].ID)
+			assert.Equal(t, color.Black, sc.Background)
+		})
+	}
+}
@@ -2,20 +2,69 @@ package lang
 
 import (
 	"bufio"
+	"errors"
+	"fmt"
 	"io"
 	"log"
+	"math"
+	"regexp"
+	"strconv"
 	"strings"
+	"unicode"
 
 	"github.com/gothicenemy/software-architecture-3/painter"
 )
 
-type Parser struct{}
+type Mode int
 
-func (p *Parser) Parse(r io.Reader) ([]painter.Operation, error) {
+const (
+	// Lenient skips invalid lines and keeps clamped coordinates.
+	Lenient Mode = iota
+	// Strict rejects the whole script if any line produced a diagnostic.
+	Strict
+)
+
+func (m Mode) String() string {
+	if m == Strict {
+		return "strict"
+	}
+	return "lenient"
+}
+
+func ParseMode(s string) (Mode, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "lenient":
+		return Lenient, nil
+	case "strict":
+		return Strict, nil
+	default:
+		return Lenient, fmt.Errorf("unknown parse mode %q", s)
+	}
+}
+
+// ErrRejected is returned by a strict parser when the script produced
+// diagnostics. No operations are returned alongside it.
+var ErrRejected = errors.New("script rejected")
+
+type Parser struct {
+	Mode Mode
+	// Exact keeps coordinates and offsets as written instead of clamping
+	// them. It is meant for machine-written scripts such as the journal,
+	// which may hold figures shifted off the canvas.
+	Exact bool
+}
+
+// Parse reads a script and returns the operations of every valid line along
+// with diagnostics for the lines that were skipped or adjusted. The error is
+// reserved for failures of the underlying reader and for scripts rejected in
+// strict mode.
+func (p *Parser) Parse(r io.Reader) ([]painter.Operation, Diagnostics, error) {
 	scanner := bufio.NewScanner(r)
 	scanner.Split(bufio.ScanLines)
 
 	var res []painter.Operation
+	var tx *transaction
+	diags := Diagnostics{}
 	lineNum := 0
 
 	for scanner.Scan() {
@@ -24,7 +73,7 @@ func (p *Parser) Parse(r io.Reader) ([]painter.Operation, error) {
 		lineForParsing := commandLine
 
 		// !! Видалення коментаря перед обробкою !!
-		if commentIndex := strings.Index(lineForParsing, "#"); commentIndex != -1 {
+		if commentIndex := commentStart(lineForParsing); commentIndex != -1 {
 			lineForParsing = lineForParsing[:commentIndex]
 		}
 		// -------------------------------------------------
@@ -35,80 +84,527 @@ func (p *Parser) Parse(r io.Reader) ([]painter.Operation, error) {
 			continue
 		}
 
-		op := parseLine(trimmedLine)
-		if op != nil {
-			res = append(res, op)
+		lp := newLineParser(lineNum, lineForParsing)
+		lp.exact = p.Exact
+		var op painter.Operation
+		switch lp.command {
+		case "begin", "commit", "rollback":
+			op, tx = lp.parseTransaction(tx)
+		default:
+			op = lp.parse()
+		}
+		diags = append(diags, lp.diags...)
+		if tx != nil && lp.diags.HasErrors() {
+			tx.failed = true
+		}
+		if op == nil {
+			continue
+		}
+		if tx != nil {
+			tx.ops = append(tx.ops, op)
 		} else {
-			log.Printf("Warning: Skipping invalid command line %d: %s", lineNum, commandLine)
+			res = append(res, op)
 		}
 	}
+	if tx != nil {
+		diags = append(diags, Diagnostic{
+			Line:     tx.line,
+			Column:   tx.col,
+			Command:  "begin",
+			ArgIndex: -1,
+			Severity: SeverityError,
+			Message:  "'begin' without 'commit', transaction discarded",
+		})
+	}
 
 	if err := scanner.Err(); err != nil {
 		log.Printf("Error reading input: %v", err)
-		return nil, err
+		return nil, diags, err
 	}
 
-	log.Printf("Parsing finished. Found %d valid operations.", len(res))
-	return res, nil
+	if p.Mode == Strict && len(diags) > 0 {
+		log.Printf("Parsing rejected in strict mode: %d diagnostics.", len(diags))
+		return nil, diags, fmt.Errorf("%w: %d diagnostics in strict mode", ErrRejected, len(diags))
+	}
+
+	log.Printf("Parsing finished. Found %d valid operations, %d diagnostics.", len(res), len(diags))
+	return res, diags, nil
 }
 
-func parseLine(line string) painter.Operation {
-	fields := strings.Fields(line)
-	if len(fields) == 0 {
-		return nil
+// transaction collects the operations between "begin" and "commit".
+type transaction struct {
+	line, col int
+	ops       []painter.Operation
+	// failed is set when a line inside the transaction had an error.
+	failed bool
+}
+
+// parseTransaction handles "begin", "commit" and "rollback" given the open
+// transaction, if any. It returns the batch to emit on a successful commit
+// and the transaction that is open after the line.
+func (lp *lineParser) parseTransaction(tx *transaction) (painter.Operation, *transaction) {
+	if !lp.noArgs() {
+		return nil, tx
+	}
+	if lp.command == "begin" {
+		if tx != nil {
+			lp.errorf(-1, "transaction from line %d is still open", tx.line)
+			return nil, tx
+		}
+		return nil, &transaction{line: lp.line, col: lp.fields[0].col}
+	}
+	if tx == nil {
+		lp.errorf(-1, "'%s' without 'begin'", lp.command)
+		return nil, nil
+	}
+	switch {
+	case lp.command == "rollback":
+		log.Printf("Transaction from line %d rolled back: %d operations discarded", tx.line, len(tx.ops))
+		return nil, nil
+	case tx.failed:
+		lp.errorf(-1, "transaction from line %d discarded because of errors", tx.line)
+		return nil, nil
+	case len(tx.ops) == 0:
+		return nil, nil
+	}
+	return painter.BatchOperation{Ops: tx.ops}, nil
+}
+
+// commentStart returns the index of the '#' that opens a comment, or -1.
+// A hex argument such as "#ff8800" is a color, not a comment, where the
+// command expects a color: the first argument of bg and the fifth of bgrect.
+func commentStart(line string) int {
+	command := ""
+	if f := strings.Fields(line); len(f) > 0 {
+		command = strings.ToLower(f[0])
+	}
+	field := -1
+	for i := 0; i < len(line); i++ {
+		startsField := !isBlank(line[i]) && (i == 0 || isBlank(line[i-1]))
+		if startsField {
+			field++
+		}
+		if line[i] != '#' {
+			continue
+		}
+		if startsField && isColorArgument(command, field) && isHexColorToken(line[i+1:]) {
+			continue
+		}
+		return i
 	}
+	return -1
+}
 
-	command := strings.ToLower(fields[0])
-	args := fields[1:]
+func isBlank(c byte) bool { return c == ' ' || c == '\t' }
 
+// isColorArgument reports whether the argument at position field (the
+// command is field 0) is where command takes a color.
+func isColorArgument(command string, field int) bool {
 	switch command {
+	case "bg":
+		return field == 1
+	case "bgrect":
+		return field == 5
+	}
+	return false
+}
+
+func isHexColorToken(s string) bool {
+	n := 0
+	for n < len(s) && strings.IndexByte("0123456789abcdefABCDEF", s[n]) >= 0 {
+		n++
+	}
+	if n < len(s) && !unicode.IsSpace(rune(s[n])) {
+		return false
+	}
+	return n > 0
+}
+
+type field struct {
+	text string
+	col  int
+}
+
+// splitFields splits a line like strings.Fields but keeps the 1-based
+// column at which every field starts.
+func splitFields(line string) []field {
+	var fields []field
+	start := -1
+	for i, r := range line {
+		if unicode.IsSpace(r) {
+			if start >= 0 {
+				fields = append(fields, field{text: line[start:i], col: start + 1})
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		fields = append(fields, field{text: line[start:], col: start + 1})
+	}
+	return fields
+}
+
+type lineParser struct {
+	line    int
+	command string
+	fields  []field
+	diags   Diagnostics
+	exact   bool
+}
+
+func newLineParser(line int, text string) *lineParser {
+	fields := splitFields(text)
+	return &lineParser{
+		line:    line,
+		command: strings.ToLower(fields[0].text),
+		fields:  fields,
+	}
+}
+
+func (lp *lineParser) args() []string {
+	args := make([]string, 0, len(lp.fields)-1)
+	for _, f := range lp.fields[1:] {
+		args = append(args, f.text)
+	}
+	return args
+}
+
+func (lp *lineParser) report(severity Severity, argIndex int, format string, a ...interface{}) {
+	col := lp.fields[0].col
+	if argIndex >= 0 && argIndex+1 < len(lp.fields) {
+		col = lp.fields[argIndex+1].col
+	}
+	lp.diags = append(lp.diags, Diagnostic{
+		Line:     lp.line,
+		Column:   col,
+		Command:  lp.command,
+		ArgIndex: argIndex,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, a...),
+	})
+}
+
+func (lp *lineParser) errorf(argIndex int, format string, a ...interface{}) {
+	lp.report(SeverityError, argIndex, format, a...)
+}
+
+func (lp *lineParser) warnf(argIndex int, format string, a ...interface{}) {
+	lp.report(SeverityWarning, argIndex, format, a...)
+}
+
+func (lp *lineParser) noArgs() bool {
+	if n := len(lp.fields) - 1; n != 0 {
+		lp.errorf(0, "'%s' expects 0 arguments, got %d", lp.command, n)
+		return false
+	}
+	return true
+}
+
+// coords parses count coordinates from args, which start at argument
+// position offset of the line.
+func (lp *lineParser) coords(args []string, offset, count int) ([]float64, bool) {
+	if lp.exact {
+		return lp.numbers(painter.ParseFloats, args, offset, count)
+	}
+	return lp.numbers(painter.ParseCoords, args, offset, count)
+}
+
+func (lp *lineParser) offsets(args []string, offset, count int) ([]float64, bool) {
+	if lp.exact {
+		return lp.numbers(painter.ParseFloats, args, offset, count)
+	}
+	return lp.numbers(painter.ParseOffsets, args, offset, count)
+}
+
+func (lp *lineParser) numbers(parse func([]string, int) ([]float64, []painter.ClampWarning, error), args []string, offset, count int) ([]float64, bool) {
+	coords, warnings, err := parse(args, count)
+	if err != nil {
+		argIndex := -1
+		var coordErr *painter.CoordError
+		if errors.As(err, &coordErr) && coordErr.Index >= 0 {
+			argIndex = offset + coordErr.Index
+		}
+		lp.errorf(argIndex, "%v", err)
+		return nil, false
+	}
+	for _, w := range warnings {
+		lp.warnf(offset+w.Index, "%s", w)
+	}
+	return coords, true
+}
+
+// fileNamePattern keeps save and load inside the scene directory.
+var fileNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9_.-]*$`)
+
+type option struct {
+	key, value string
+	index      int
+}
+
+// options collects the leading key=value arguments and returns them along
+// with the position of the first positional argument.
+func (lp *lineParser) options() ([]option, int) {
+	var opts []option
+	args := lp.args()
+	for i, a := range args {
+		key, value, ok := strings.Cut(a, "=")
+		if !ok {
+			return opts, i
+		}
+		opts = append(opts, option{key: strings.ToLower(key), value: value, index: i})
+	}
+	return opts, len(args)
+}
+
+func (lp *lineParser) name(opt option) bool {
+	if !painter.ValidName(opt.value) {
+		lp.errorf(opt.index, "invalid %s %q", opt.key, opt.value)
+		return false
+	}
+	return true
+}
+
+// parseFigure handles "figure [id=NAME] [tag=NAME]... x y".
+func (lp *lineParser) parseFigure() painter.Operation {
+	opts, n := lp.options()
+	var op painter.FigureOperation
+	for _, opt := range opts {
+		switch opt.key {
+		case "id":
+			if op.ID != "" {
+				lp.errorf(opt.index, "id given more than once")
+				return nil
+			}
+			if !lp.name(opt) {
+				return nil
+			}
+			op.ID = opt.value
+		case "tag":
+			if !lp.name(opt) {
+				return nil
+			}
+			op.Tags = append(op.Tags, opt.value)
+		default:
+			lp.errorf(opt.index, "unknown option '%s'", opt.key)
+			return nil
+		}
+	}
+	coords, ok := lp.coords(lp.args()[n:], n, 2)
+	if !ok {
+		return nil
+	}
+	op.X, op.Y = coords[0], coords[1]
+	return op
+}
+
+// targetOptions parses the leading arguments of move and shift: a figure
+// selector ("all", "id=NAME", "tag=NAME" or a bare figure id) and, when
+// allowLayout is set, "layout=keep|collapse". It returns the position of
+// the first coordinate argument.
+func (lp *lineParser) targetOptions(allowLayout bool) (target painter.Target, keepLayout bool, n int, ok bool) {
+	args := lp.args()
+	hasTarget := false
+	setTarget := func(i int, t painter.Target) bool {
+		if hasTarget {
+			lp.errorf(i, "more than one target given")
+			return false
+		}
+		target, hasTarget = t, true
+		return true
+	}
+	for n = 0; n < len(args); n++ {
+		arg := args[n]
+		if _, err := strconv.ParseFloat(arg, 64); err == nil {
+			break
+		}
+		if strings.EqualFold(arg, "all") {
+			if !setTarget(n, painter.Target{}) {
+				return target, false, n, false
+			}
+			continue
+		}
+		key, value, isOption := strings.Cut(arg, "=")
+		if !isOption {
+			if !painter.ValidName(arg) {
+				break
+			}
+			if !setTarget(n, painter.Target{ID: arg}) {
+				return target, false, n, false
+			}
+			continue
+		}
+		opt := option{key: strings.ToLower(key), value: value, index: n}
+		switch {
+		case opt.key == "id" || opt.key == "tag":
+			if !lp.name(opt) {
+				return target, false, n, false
+			}
+			t := painter.Target{ID: value}
+			if opt.key == "tag" {
+				t = painter.Target{Tag: value}
+			}
+			if !setTarget(n, t) {
+				return target, false, n, false
+			}
+		case opt.key == "layout" && allowLayout:
+			switch strings.ToLower(value) {
+			case "keep":
+				keepLayout = true
+			case "collapse":
+				keepLayout = false
+			default:
+				lp.errorf(n, "layout must be 'keep' or 'collapse', got %q", value)
+				return target, false, n, false
+			}
+		default:
+			lp.errorf(n, "unknown option '%s'", arg)
+			return target, false, n, false
+		}
+	}
+	return target, keepLayout, n, true
+}
+
+func (lp *lineParser) parse() painter.Operation {
+	switch lp.command {
 	case "white":
-		if len(args) != 0 {
-			log.Println("Error parsing 'white': expects 0 arguments")
+		if !lp.noArgs() {
 			return nil
 		}
 		return painter.WhiteOperation{}
 	case "green":
-		if len(args) != 0 {
-			log.Println("Error parsing 'green': expects 0 arguments")
+		if !lp.noArgs() {
 			return nil
 		}
 		return painter.GreenOperation{}
+	case "bg":
+		if len(lp.fields) < 2 {
+			lp.errorf(-1, "'bg' expects a color argument")
+			return nil
+		}
+		c, err := painter.ParseColor(strings.Join(lp.args(), " "))
+		if err != nil {
+			lp.errorf(0, "%v", err)
+			return nil
+		}
+		return painter.BackgroundOperation{Color: c}
 	case "update":
-		if len(args) != 0 {
-			log.Println("Error parsing 'update': expects 0 arguments")
+		if !lp.noArgs() {
 			return nil
 		}
 		return painter.UpdateOperation{}
 	case "bgrect":
-		coords, ok := painter.ParseCoords(args, 4)
-		if !ok {
+		return lp.parseBgRect()
+	case "bgclear":
+		if !lp.noArgs() {
 			return nil
 		}
-		if coords[0] >= coords[2] || coords[1] >= coords[3] {
-			log.Printf("Warning: Invalid bgrect coordinates %.2f,%.2f -> %.2f,%.2f (x1>=x2 or y1>=y2)", coords[0], coords[1], coords[2], coords[3])
+		return painter.BgClearOperation{}
+	case "bgpop":
+		if !lp.noArgs() {
+			return nil
 		}
-		return painter.BgRectOperation{X1: coords[0], Y1: coords[1], X2: coords[2], Y2: coords[3]}
+		return painter.BgPopOperation{}
 	case "figure":
-		coords, ok := painter.ParseCoords(args, 2)
+		return lp.parseFigure()
+	case "move":
+		target, keepLayout, n, ok := lp.targetOptions(true)
 		if !ok {
 			return nil
 		}
-		return painter.FigureOperation{X: coords[0], Y: coords[1]}
-	case "move":
-		coords, ok := painter.ParseCoords(args, 2)
+		coords, ok := lp.coords(lp.args()[n:], n, 2)
 		if !ok {
 			return nil
 		}
-		return painter.MoveOperation{X: coords[0], Y: coords[1]}
+		return painter.MoveOperation{X: coords[0], Y: coords[1], Target: target, KeepLayout: keepLayout}
+	case "shift":
+		target, _, n, ok := lp.targetOptions(false)
+		if !ok {
+			return nil
+		}
+		offsets, ok := lp.offsets(lp.args()[n:], n, 2)
+		if !ok {
+			return nil
+		}
+		return painter.ShiftOperation{DX: offsets[0], DY: offsets[1], Target: target}
+	case "save", "load":
+		args := lp.args()
+		if len(args) != 1 {
+			lp.errorf(-1, "'%s' expects a file name", lp.command)
+			return nil
+		}
+		if !fileNamePattern.MatchString(args[0]) {
+			lp.errorf(0, "invalid file name %q: use letters, digits, '_', '-' and '.' only", args[0])
+			return nil
+		}
+		if lp.command == "save" {
+			return painter.SaveOperation{Path: args[0]}
+		}
+		return painter.LoadOperation{Path: args[0]}
 	case "reset":
-		if len(args) != 0 {
-			log.Println("Error parsing 'reset': expects 0 arguments")
+		if !lp.noArgs() {
 			return nil
 		}
 		return painter.ResetOperation{}
+	case "undo":
+		if !lp.noArgs() {
+			return nil
+		}
+		return painter.UndoOperation{}
+	case "redo":
+		if !lp.noArgs() {
+			return nil
+		}
+		return painter.RedoOperation{}
 	default:
-		log.Printf("Error: Unknown command '%s'", command)
+		lp.errorf(-1, "unknown command '%s'", lp.command)
+		return nil
+	}
+}
+
+// parseBgRect handles "bgrect x1 y1 x2 y2 [color [opacity]]".
+func (lp *lineParser) parseBgRect() painter.Operation {
+	args := lp.args()
+	coordArgs := args
+	if len(coordArgs) > 4 {
+		coordArgs = coordArgs[:4]
+	}
+	coords, ok := lp.coords(coordArgs, 0, 4)
+	if !ok {
+		return nil
+	}
+	if coords[0] >= coords[2] || coords[1] >= coords[3] {
+		lp.warnf(-1, "empty rectangle %.2f,%.2f -> %.2f,%.2f (x1>=x2 or y1>=y2)", coords[0], coords[1], coords[2], coords[3])
+	}
+	op := painter.BgRectOperation{X1: coords[0], Y1: coords[1], X2: coords[2], Y2: coords[3]}
+
+	rest := args[4:]
+	if len(rest) == 0 {
+		return op
+	}
+	opacity := 1.0
+	if last := len(rest) - 1; last > 0 {
+		if v, err := strconv.ParseFloat(rest[last], 64); err == nil {
+			if math.IsNaN(v) {
+				lp.errorf(4+last, "opacity is not a number")
+				return nil
+			}
+			opacity = v
+			if v < 0 || v > 1 {
+				opacity = math.Min(math.Max(v, 0), 1)
+				lp.warnf(4+last, "opacity clamped from %g to %g", v, opacity)
+			}
+			rest = rest[:last]
+		}
+	}
+	c, err := painter.ParseColor(strings.Join(rest, " "))
+	if err != nil {
+		lp.errorf(4, "%v", err)
 		return nil
 	}
+	op.Color = painter.WithOpacity(c, opacity)
+	return op
 }
@@ -1,6 +1,8 @@
 package lang_test
 
 import (
+	"fmt"
+	"image/color"
 	"strings"
 	"testing"
 
@@ -27,9 +29,10 @@ figure 0.0 1.0 # Coordinates within range
 bgrect 0 0 1 1
 `
 	reader := strings.NewReader(input)
-	ops, err := p.Parse(reader)
+	ops, diags, err := p.Parse(reader)
 
 	require.NoError(t, err)
+	assert.Empty(t, diags)
 	require.Len(t, ops, 9)
 
 	assert.IsType(t, painter.WhiteOperation{}, ops[0])
@@ -77,21 +80,334 @@ func TestParser_Parse_InvalidCommands(t *testing.T) {
 		{"Empty", "", 0}, {"Only Comment", "# white", 0}, {"Unknown Command", "red", 0},
 		{"White With Arg", "white 0.5", 0}, {"Green With Arg", "green 1", 0},
 		{"Update With Arg", "update status", 0}, {"Reset With Arg", "reset now", 0},
-		{"BgRect Wrong Arg Count", "bgrect 0.1 0.2 0.3", 0}, {"BgRect Wrong Arg Type", "bgrect 0.1 0.2 text 0.4", 0},
+		{"BgRect Wrong Arg Count", "bgrect 0.1 0.2 0.3", 0}, {"BgRect Bad Color", "bgrect 0.1 0.2 0.3 0.4 nocolor", 0},
+		{"BgClear With Arg", "bgclear all", 0}, {"BgPop With Arg", "bgpop 1", 0}, {"BgRect Wrong Arg Type", "bgrect 0.1 0.2 text 0.4", 0},
 		{"BgRect Out Of Range", "bgrect -0.1 0.2 0.8 1.1", 1},
 		{"Figure Wrong Arg Count", "figure 0.5", 0}, {"Figure Wrong Arg Type", "figure text 0.5", 0},
 		{"Figure Out Of Range", "figure 1.2 0.5", 1},
 		{"Move Wrong Arg Count", "move 0.5", 0}, {"Move Wrong Arg Type", "move 0.5 text", 0},
 		{"Move Out Of Range", "move 0.5 -0.2", 1},
+		{"Figure Duplicate Id Option", "figure id=a id=b 0.5 0.5", 0}, {"Figure Unknown Option", "figure color=red 0.5 0.5", 0},
+		{"Figure Invalid Id", "figure id=1a 0.5 0.5", 0}, {"Move Unknown Target", "move size=2 0.5 0.5", 0},
+		{"Move Target Without Coords", "move a", 0}, {"Move Two Targets", "move a b 0.5 0.5", 0},
+		{"Move Bad Layout", "move layout=spread 0.5 0.5", 0}, {"Shift Layout", "shift layout=keep 0.1 0.1", 0},
+		{"Save Without Name", "save", 0}, {"Save Path", "save ../etc/passwd", 0}, {"Load Two Names", "load a b", 0},
+		{"Shift Wrong Arg Count", "shift 0.1", 0}, {"Shift Out Of Range", "shift -1.5 0.5", 1},
 		{"Mixed Valid Invalid", "white\nfigure 0.1\nupdate", 2},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			reader := strings.NewReader(tt.input)
-			ops, err := p.Parse(reader)
+			ops, diags, err := p.Parse(reader)
 			require.NoError(t, err)
 			assert.Len(t, ops, tt.want)
+			if tt.want == 0 && tt.input != "" && !strings.HasPrefix(tt.input, "#") {
+				assert.True(t, diags.HasErrors())
+			}
 		})
 	}
 }
+
+func TestParser_Parse_Diagnostics(t *testing.T) {
+	p := &lang.Parser{}
+	input := "white\nfigure 0.5 abc\n  move 1.5 0.5\nred\nreset now"
+	ops, diags, err := p.Parse(strings.NewReader(input))
+	require.NoError(t, err)
+	require.Len(t, ops, 2)
+	require.Len(t, diags, 4)
+
+	assert.Equal(t, lang.Diagnostic{
+		Line: 2, Column: 12, Command: "figure", ArgIndex: 1,
+		Severity: lang.SeverityError, Message: diags[0].Message,
+	}, diags[0])
+	assert.Contains(t, diags[0].Message, "abc")
+
+	assert.Equal(t, 3, diags[1].Line)
+	assert.Equal(t, 8, diags[1].Column)
+	assert.Equal(t, 0, diags[1].ArgIndex)
+	assert.Equal(t, lang.SeverityWarning, diags[1].Severity)
+	if moveOp, ok := ops[1].(painter.MoveOperation); assert.True(t, ok) {
+		assert.InDelta(t, 1.0, moveOp.X, 0.001)
+	}
+
+	assert.Equal(t, 4, diags[2].Line)
+	assert.Equal(t, 1, diags[2].Column)
+	assert.Equal(t, -1, diags[2].ArgIndex)
+	assert.Equal(t, lang.SeverityError, diags[2].Severity)
+
+	assert.Equal(t, 5, diags[3].Line)
+	assert.Equal(t, 7, diags[3].Column)
+	assert.Equal(t, "reset", diags[3].Command)
+	assert.True(t, diags.HasErrors())
+}
+
+func TestParser_Parse_StrictMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		rejected bool
+	}{
+		{"Valid", "white\nfigure 0.5 0.5\nupdate", false},
+		{"Invalid Line", "white\nfigure 0.5\nupdate", true},
+		{"Clamped Coordinate", "white\nmove 1.2 0.5\nupdate", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &lang.Parser{Mode: lang.Strict}
+			ops, diags, err := p.Parse(strings.NewReader(tt.input))
+			if tt.rejected {
+				require.ErrorIs(t, err, lang.ErrRejected)
+				assert.Empty(t, ops)
+				assert.NotEmpty(t, diags)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, ops, 3)
+		})
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	m, err := lang.ParseMode("")
+	require.NoError(t, err)
+	assert.Equal(t, lang.Lenient, m)
+
+	m, err = lang.ParseMode("Strict")
+	require.NoError(t, err)
+	assert.Equal(t, lang.Strict, m)
+
+	_, err = lang.ParseMode("paranoid")
+	assert.Error(t, err)
+}
+
+func TestParser_Parse_Background(t *testing.T) {
+	p := &lang.Parser{}
+	input := `
+bg #ff8800 # orange
+bg #10203040
+bg rgb(1, 2, 3)
+bg hsl(120, 100%, 50%)
+bg CornflowerBlue
+bg #12345
+bg rgb(1,2)
+bg
+`
+	ops, diags, err := p.Parse(strings.NewReader(input))
+	require.NoError(t, err)
+	require.Len(t, ops, 5)
+	require.Len(t, diags, 3)
+
+	want := []color.NRGBA{
+		{R: 0xff, G: 0x88, A: 0xff},
+		{R: 0x10, G: 0x20, B: 0x30, A: 0x40},
+		{R: 1, G: 2, B: 3, A: 0xff},
+		{G: 0xff, A: 0xff},
+		{R: 0x64, G: 0x95, B: 0xed, A: 0xff},
+	}
+	for i, w := range want {
+		if bgOp, ok := ops[i].(painter.BackgroundOperation); assert.True(t, ok) {
+			assert.Equal(t, w, bgOp.Color)
+		}
+	}
+	for _, d := range diags {
+		assert.Equal(t, "bg", d.Command)
+		assert.Equal(t, lang.SeverityError, d.Severity)
+	}
+	assert.Equal(t, 4, diags[0].Column)
+}
+
+func TestParser_Parse_TrailingHexComments(t *testing.T) {
+	p := &lang.Parser{Mode: lang.Strict}
+	input := `
+white #add
+figure 0.5 0.5 #cafe
+move 0.25 0.25 #beef
+bg #fff #add
+bgrect 0 0 1 1 #00f #cafe
+bgrect 0 0 1 1 #bad
+`
+	ops, diags, err := p.Parse(strings.NewReader(input))
+	require.NoError(t, err)
+	assert.Empty(t, diags)
+	require.Len(t, ops, 6)
+	assert.Equal(t, painter.WhiteOperation{}, ops[0])
+	assert.Equal(t, painter.FigureOperation{X: 0.5, Y: 0.5}, ops[1])
+	assert.Equal(t, painter.MoveOperation{X: 0.25, Y: 0.25}, ops[2])
+	assert.Equal(t, color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}, ops[3].(painter.BackgroundOperation).Color)
+	assert.Equal(t, color.NRGBA{B: 0xff, A: 0xff}, ops[4].(painter.BgRectOperation).Color)
+	assert.Equal(t, color.NRGBA{R: 0xbb, G: 0xaa, B: 0xdd, A: 0xff}, ops[5].(painter.BgRectOperation).Color)
+}
+
+func TestParser_Parse_BgRectColor(t *testing.T) {
+	p := &lang.Parser{}
+	input := `
+bgrect 0.1 0.1 0.5 0.5 red
+bgrect 0.2 0.2 0.6 0.6 rgb(0, 0, 255) 0.5
+bgrect 0 0 1 1 #00ff0080 2
+bgpop
+bgclear
+`
+	ops, diags, err := p.Parse(strings.NewReader(input))
+	require.NoError(t, err)
+	require.Len(t, ops, 5)
+	require.Len(t, diags, 1)
+	assert.Equal(t, lang.SeverityWarning, diags[0].Severity)
+	assert.Equal(t, 5, diags[0].ArgIndex)
+
+	want := []color.NRGBA{
+		{R: 0xff, A: 0xff},
+		{B: 0xff, A: 0x80},
+		{G: 0xff, A: 0x80},
+	}
+	for i, w := range want {
+		if rectOp, ok := ops[i].(painter.BgRectOperation); assert.True(t, ok) {
+			assert.Equal(t, w, rectOp.Color)
+		}
+	}
+	assert.IsType(t, painter.BgPopOperation{}, ops[3])
+	assert.IsType(t, painter.BgClearOperation{}, ops[4])
+}
+
+func TestParser_Parse_NamedFigures(t *testing.T) {
+	p := &lang.Parser{}
+	input := `
+figure id=a 0.2 0.3
+figure id=b tag=top tag=left 0.4 0.5
+move a 0.1 0.1
+move id=b 0.2 0.2
+move tag=top 0.3 0.3
+move all 0.4 0.4
+move 0.5 0.5
+figure id=c 0.1 1.5
+`
+	ops, diags, err := p.Parse(strings.NewReader(input))
+	require.NoError(t, err)
+	require.Len(t, ops, 8)
+	require.Len(t, diags, 1)
+	assert.Equal(t, 2, diags[0].ArgIndex)
+	assert.Equal(t, 17, diags[0].Column)
+
+	assert.Equal(t, painter.FigureOperation{X: 0.2, Y: 0.3, ID: "a"}, ops[0])
+	assert.Equal(t, painter.FigureOperation{X: 0.4, Y: 0.5, ID: "b", Tags: []string{"top", "left"}}, ops[1])
+
+	targets := []painter.Target{{ID: "a"}, {ID: "b"}, {Tag: "top"}, {}, {}}
+	for i, target := range targets {
+		if moveOp, ok := ops[2+i].(painter.MoveOperation); assert.True(t, ok) {
+			assert.Equal(t, target, moveOp.Target)
+		}
+	}
+}
+
+func TestParser_Parse_ShiftAndLayout(t *testing.T) {
+	p := &lang.Parser{}
+	input := `
+shift 0.1 -0.2
+shift tag=row -0.5 0.5
+move layout=keep 0.5 0.5
+move tag=row layout=keep 0.3 0.3
+move a layout=collapse 0.1 0.1
+`
+	ops, diags, err := p.Parse(strings.NewReader(input))
+	require.NoError(t, err)
+	assert.Empty(t, diags)
+	require.Len(t, ops, 5)
+
+	assert.Equal(t, painter.ShiftOperation{DX: 0.1, DY: -0.2}, ops[0])
+	assert.Equal(t, painter.ShiftOperation{DX: -0.5, DY: 0.5, Target: painter.Target{Tag: "row"}}, ops[1])
+	assert.Equal(t, painter.MoveOperation{X: 0.5, Y: 0.5, KeepLayout: true}, ops[2])
+	assert.Equal(t, painter.MoveOperation{X: 0.3, Y: 0.3, Target: painter.Target{Tag: "row"}, KeepLayout: true}, ops[3])
+	assert.Equal(t, painter.MoveOperation{X: 0.1, Y: 0.1, Target: painter.Target{ID: "a"}}, ops[4])
+}
+
+func TestParser_Parse_SaveLoad(t *testing.T) {
+	p := &lang.Parser{}
+	ops, diags, err := p.Parse(strings.NewReader("save demo.json\nload demo.json"))
+	require.NoError(t, err)
+	assert.Empty(t, diags)
+	assert.Equal(t, []painter.Operation{
+		painter.SaveOperation{Path: "demo.json"},
+		painter.LoadOperation{Path: "demo.json"},
+	}, ops)
+}
+
+func TestParser_Parse_ExactRoundTrip(t *testing.T) {
+	ops := []painter.Operation{
+		painter.WhiteOperation{},
+		painter.GreenOperation{},
+		painter.BackgroundOperation{Color: color.NRGBA{R: 0x12, G: 0x34, B: 0x56, A: 0xff}},
+		painter.BgRectOperation{X1: 0.1, Y1: 0.2, X2: 0.3, Y2: 0.4, Color: color.NRGBA{R: 0xff, A: 0x80}},
+		painter.BgClearOperation{},
+		painter.BgPopOperation{},
+		painter.FigureOperation{X: 1.25, Y: -0.5, ID: "a", Tags: []string{"row", "red"}},
+		painter.MoveOperation{X: 1.0 / 3, Y: 0.5, Target: painter.Target{Tag: "row"}, KeepLayout: true},
+		painter.ShiftOperation{DX: 2.5, DY: -0.1, Target: painter.Target{ID: "a"}},
+		painter.UpdateOperation{},
+		painter.ResetOperation{},
+	}
+	var script strings.Builder
+	for _, op := range ops {
+		script.WriteString(op.(fmt.Stringer).String() + "\n")
+	}
+
+	p := &lang.Parser{Mode: lang.Strict, Exact: true}
+	parsed, diags, err := p.Parse(strings.NewReader(script.String()))
+	require.NoError(t, err, "diagnostics: %v", diags)
+	assert.Equal(t, ops, parsed)
+
+	_, diags, err = (&lang.Parser{}).Parse(strings.NewReader("shift 2.5 0"))
+	require.NoError(t, err)
+	assert.Len(t, diags, 1, "offsets are clamped without Exact")
+}
+
+func TestParser_Parse_UndoRedo(t *testing.T) {
+	p := &lang.Parser{}
+	ops, diags, err := p.Parse(strings.NewReader("undo\nredo\nundo 2"))
+	require.NoError(t, err)
+	assert.Equal(t, []painter.Operation{painter.UndoOperation{}, painter.RedoOperation{}}, ops)
+	require.Len(t, diags, 1)
+	assert.Equal(t, 3, diags[0].Line)
+}
+
+func TestParser_Parse_Transactions(t *testing.T) {
+	p := &lang.Parser{}
+	input := `
+white
+begin
+figure id=a 0.1 0.1
+update
+commit
+begin
+figure 0.2 0.2
+rollback
+begin
+figure 0.3 0.3
+figure 0.3
+commit
+begin
+update
+`
+	ops, diags, err := p.Parse(strings.NewReader(input))
+	require.NoError(t, err)
+	assert.Equal(t, []painter.Operation{
+		painter.WhiteOperation{},
+		painter.BatchOperation{Ops: []painter.Operation{
+			painter.FigureOperation{X: 0.1, Y: 0.1, ID: "a"},
+			painter.UpdateOperation{},
+		}},
+	}, ops)
+
+	require.Len(t, diags, 3)
+	assert.Equal(t, 12, diags[0].Line)
+	assert.Equal(t, 13, diags[1].Line)
+	assert.Contains(t, diags[1].Message, "transaction from line 10 discarded")
+	assert.Equal(t, 14, diags[2].Line)
+	assert.Contains(t, diags[2].Message, "'begin' without 'commit'")
+
+	_, diags, err = p.Parse(strings.NewReader("commit\nbegin\nbegin\nrollback"))
+	require.NoError(t, err)
+	require.Len(t, diags, 2)
+	assert.Contains(t, diags[0].Message, "'commit' without 'begin'")
+	assert.Contains(t, diags[1].Message, "transaction from line 2 is still open")
+}
@@ -1,9 +1,12 @@
 package painter
 
 import (
+	"context"
+	"errors"
 	"image"
 	"image/color"
 	"log"
+	"time"
 
 	"golang.org/x/exp/shiny/screen"
 )
@@ -12,6 +15,12 @@ type Receiver interface {
 	Update(t screen.Texture)
 }
 
+// Recorder is notified on the loop goroutine after every operation that
+// changed the scene.
+type Recorder interface {
+	Record(op Operation, state *LoopState)
+}
+
 type Operation interface {
 	Do(state *LoopState) (requestUpdate bool)
 }
@@ -19,166 +28,539 @@ type Operation interface {
 type LoopState struct {
 	Texture    screen.Texture
 	Background color.Color
-	BgRect     *RelativeRectangle
+	BgRects    []BgRect
 	Figures    []*Figure
 	Screen     screen.Screen
 	WindowSize image.Point
+	// SceneDir is the directory save and load resolve scene files against.
+	SceneDir string
+	// History holds the scenes for undo and redo; nil disables it.
+	History *History
+	// err is the failure reported by the operation being applied.
+	err error
+	// version counts the changes to the scene, see Changed.
+	version uint64
+	// intercept applies an operation through the interceptors of the loop,
+	// see Loop.intercept. Nil outside a loop.
+	intercept func(op Operation) (Operation, bool, bool)
+}
+
+// Fail reports that the operation being applied failed with err. It is
+// meant to be called from Operation.Do.
+func (s *LoopState) Fail(err error) {
+	log.Printf("Error: %v", err)
+	s.err = err
+}
+
+// Changed records that the operation being applied changed the scene.
+// Operations that modify Background, BgRects or Figures must call it so
+// that undo and the Recorder see the change.
+func (s *LoopState) Changed() {
+	s.version++
 }
 
+func (s *LoopState) setBackground(c color.Color) {
+	if s.Background != c {
+		s.Background = c
+		s.Changed()
+	}
+}
+
+// apply runs op on behalf of an operation that contains it, through the
+// interceptors of the loop if there is one, and returns its update request.
+// A veto is reported with Fail like any other failure.
+func (s *LoopState) apply(op Operation) bool {
+	if s.intercept == nil {
+		return op.Do(s)
+	}
+	_, update, _ := s.intercept(op)
+	return update
+}
+
+// Figure is a T-shaped figure centered at X, Y in relative scene
+// coordinates; it is converted to pixels only when drawn.
 type Figure struct {
-	X, Y int
+	X, Y float64
+	ID   string
+	Tags []string
+}
+
+func (f *Figure) HasTag(tag string) bool {
+	for _, t := range f.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Target selects figures by id or tag. The zero value selects all figures.
+type Target struct {
+	ID  string
+	Tag string
+}
+
+func (t Target) Matches(f *Figure) bool {
+	switch {
+	case t.ID != "":
+		return f.ID == t.ID
+	case t.Tag != "":
+		return f.HasTag(t.Tag)
+	default:
+		return true
+	}
+}
+
+func (t Target) String() string {
+	switch {
+	case t.ID != "":
+		return "id=" + t.ID
+	case t.Tag != "":
+		return "tag=" + t.Tag
+	default:
+		return "all"
+	}
+}
+
+func (s *LoopState) SelectFigures(t Target) []*Figure {
+	var selected []*Figure
+	for _, f := range s.Figures {
+		if t.Matches(f) {
+			selected = append(selected, f)
+		}
+	}
+	return selected
+}
+
+func (s *LoopState) FindFigure(id string) *Figure {
+	for _, f := range s.Figures {
+		if f.ID == id {
+			return f
+		}
+	}
+	return nil
 }
 
 type RelativeRectangle struct {
 	X1, Y1, X2, Y2 float64
 }
 
+type BgRect struct {
+	RelativeRectangle
+	Color color.Color
+}
+
 type Loop struct {
+	// Receiver, if set, gets every presented frame on the loop goroutine
+	// and must not block. The texture is only valid during the call. Use
+	// Attach for receivers that may be slow or keep the frame.
 	Receiver Receiver
-	State    *LoopState
+	// Recorder, if set, sees every operation that changes the scene. It
+	// must be set before Start.
+	Recorder Recorder
+	state    *LoopState
+	clock    Clock
+	// interceptors wrap every operation, see Use.
+	interceptors []Interceptor
+	// scene is a copy of the scene at sceneVersion, kept as the undo step
+	// for the next change. Nil until it is first needed.
+	scene        *Scene
+	sceneVersion uint64
+	// fanout holds the receivers added with Attach.
+	fanout fanout
+	// chain provides the textures drawn into and presented.
+	chain *swapChain
+	// dirty is set when the texture no longer shows the scene. The scene is
+	// redrawn only when a frame is presented.
+	dirty bool
+	// frameInterval is the minimum time between presented frames, see
+	// WithMaxFPS. lastFrame is when the last one was presented.
+	frameInterval time.Duration
+	lastFrame     time.Time
+	// frameDue fires when a frame held back by frameInterval is due.
+	frameDue <-chan time.Time
+	// pending holds the acks of operations waiting for the held back frame.
+	pending  []pendingAck
 	MsgQueue chan Operation
 	stop     chan struct{}
 	stopped  chan struct{}
 }
 
-func NewLoop(s screen.Screen) *Loop {
+// DefaultQueueCapacity is the number of operations a loop queues unless
+// WithQueueCapacity says otherwise.
+const DefaultQueueCapacity = 100
+
+type LoopOption func(*Loop)
+
+// WithQueueCapacity sets how many posted operations may wait for the loop.
+func WithQueueCapacity(n int) LoopOption {
+	return func(l *Loop) {
+		if n > 0 {
+			l.MsgQueue = make(chan Operation, n)
+		}
+	}
+}
+
+// WithClock makes the loop read the time from c instead of the system clock.
+func WithClock(c Clock) LoopOption {
+	return func(l *Loop) {
+		l.clock = c
+	}
+}
+
+// WithSceneDir sets the directory save and load resolve file names against.
+func WithSceneDir(dir string) LoopOption {
+	return func(l *Loop) {
+		l.state.SceneDir = dir
+	}
+}
+
+// WithHistoryDepth sets the number of changes that can be undone; zero
+// disables undo.
+func WithHistoryDepth(n int) LoopOption {
+	return func(l *Loop) {
+		l.state.History.Depth = n
+	}
+}
+
+// WithMaxFPS limits how many frames the loop presents per second. Updates
+// that come sooner are coalesced into one frame presented when the interval
+// has passed. Zero, the default, presents a frame for every update.
+func WithMaxFPS(fps int) LoopOption {
+	return func(l *Loop) {
+		l.frameInterval = 0
+		if fps > 0 {
+			l.frameInterval = time.Second / time.Duration(fps)
+		}
+	}
+}
+
+func NewLoop(s screen.Screen, opts ...LoopOption) *Loop {
 	l := &Loop{
-		MsgQueue: make(chan Operation, 100),
+		MsgQueue: make(chan Operation, DefaultQueueCapacity),
 		stop:     make(chan struct{}),
 		stopped:  make(chan struct{}),
+		clock:    systemClock{},
+		chain:    &swapChain{screen: s},
 	}
 
 	initialSize := image.Point{X: 800, Y: 800}
 
-	l.State = &LoopState{
+	l.state = &LoopState{
 		Screen:     s,
 		Background: color.RGBA{G: 0xff, A: 0xff},
 		Figures: []*Figure{
-			{X: initialSize.X / 2, Y: initialSize.Y / 2},
+			{X: 0.5, Y: 0.5},
 		},
-		BgRect:     nil,
 		WindowSize: initialSize,
+		History:    NewHistory(DefaultHistoryDepth),
+	}
+	l.state.intercept = func(op Operation) (Operation, bool, bool) { return l.intercept(op, nil) }
+	for _, opt := range opts {
+		opt(l)
 	}
 
 	l.resetTexture()
+	l.render()
 	return l
 }
 
 func (l *Loop) resetTexture() {
-	if l.State.Texture != nil {
-		l.State.Texture.Release()
+	if l.state.Texture != nil {
+		l.state.Texture.Release()
 	}
 	var err error
-	size := l.State.WindowSize
+	size := l.state.WindowSize
 	if size.X == 0 || size.Y == 0 {
 		size = image.Point{X: 800, Y: 800}
-		l.State.WindowSize = size
+		l.state.WindowSize = size
 	}
-	l.State.Texture, err = l.State.Screen.NewTexture(size)
+	l.state.Texture, err = l.chain.acquire(size)
 	if err != nil {
 		log.Fatalf("Failed to create texture: %v", err)
 	}
 	log.Printf("Texture reset/created with size %dx%d", size.X, size.Y)
-	l.drawCurrentState()
+	l.dirty = true
 }
 
 func (l *Loop) resetState() {
-	l.State.Background = color.Black
-	l.State.BgRect = nil
-	l.State.Figures = make([]*Figure, 0)
+	l.state.Background = color.Black
+	l.state.BgRects = nil
+	l.state.Figures = make([]*Figure, 0)
+	l.dirty = true
+}
+
+// render redraws the scene if it changed since it was last drawn.
+func (l *Loop) render() {
+	if !l.dirty || l.state.Texture == nil {
+		return
+	}
 	l.drawCurrentState()
+	l.dirty = false
 }
 
 func (l *Loop) drawCurrentState() {
-	if l.State.Texture == nil {
+	if l.state.Texture == nil {
 		log.Println("Error: Loop.drawCurrentState: Texture is nil")
 		return
 	}
-	state := l.State
-	state.Texture.Fill(state.Texture.Bounds(), state.Background, screen.Src)
-	if state.BgRect != nil {
-		bounds := state.Texture.Bounds()
-		width, height := float64(bounds.Dx()), float64(bounds.Dy())
-		pxRect := image.Rect(
-			int(state.BgRect.X1*width), int(state.BgRect.Y1*height),
-			int(state.BgRect.X2*width), int(state.BgRect.Y2*height),
-		)
-		state.Texture.Fill(pxRect, color.Black, screen.Src)
+	drawState(l.state.Texture, l.state)
+}
+
+func (l *Loop) Start() {
+	log.Println("Painter loop started")
+	defer close(l.stopped)
+
+	for {
+		select {
+		case <-l.stop:
+			log.Println("Painter loop stopping...")
+			l.resolvePending()
+			l.fanout.close()
+			l.chain.close()
+			if l.state.Texture != nil {
+				l.state.Texture.Release()
+				l.state.Texture = nil
+			}
+			return
+		case op := <-l.MsgQueue:
+			l.handle(op)
+		case <-l.frameDue:
+			l.present()
+		}
+	}
+}
+
+// Step processes the next queued operation, or presents a frame that is
+// due, on the calling goroutine and reports whether there was one. Together
+// with Drain it drives a loop that was never started; it must not be used
+// while Start is running.
+func (l *Loop) Step() bool {
+	select {
+	case op := <-l.MsgQueue:
+		l.handle(op)
+		return true
+	case <-l.frameDue:
+		l.present()
+		return true
+	default:
+		return false
+	}
+}
+
+// Drain steps until the queue is empty or ctx ends.
+func (l *Loop) Drain(ctx context.Context) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !l.Step() {
+			return nil
+		}
+	}
+}
+
+// pendingAck is the ack of an operation that requested a frame still held
+// back by WithMaxFPS, with the operation's result.
+type pendingAck struct {
+	ack *Ack
+	err error
+}
+
+func (l *Loop) handle(op Operation) {
+	acked, ok := op.(ackedOperation)
+	if !ok {
+		l.process(op)
+		return
+	}
+	if acked.ctx != nil && acked.ctx.Err() != nil {
+		acked.ack.resolve(acked.ctx.Err())
+		return
 	}
-	for _, f := range state.Figures {
-		l.drawFigure(state.Texture, f.X, f.Y)
+	updateRequested, err := l.process(acked.op)
+	if updateRequested && l.frameDue != nil {
+		l.pending = append(l.pending, pendingAck{ack: acked.ack, err: err})
+		return
 	}
+	acked.ack.resolve(err)
 }
 
-func (l *Loop) drawFigure(texture screen.Texture, x, y int) {
-	bounds := texture.Bounds()
+// resolvePending resolves the acks waiting for a frame.
+func (l *Loop) resolvePending() {
+	for _, p := range l.pending {
+		p.ack.resolve(p.err)
+	}
+	l.pending = nil
+}
+
+// process applies op and, if an update was requested, presents a frame or
+// schedules one. It reports whether an update was requested and returns the
+// failure the operation reported, if any.
+func (l *Loop) process(op Operation) (bool, error) {
+	if read, ok := op.(readOperation); ok {
+		read(l.state)
+		return false, nil
+	}
+	if l.state.Texture == nil && l.state.Screen != nil {
+		log.Println("Warning: Texture was nil in loop, attempting recreate.")
+		l.resetTexture()
+		if l.state.Texture == nil {
+			log.Println("Error: Failed to recreate texture, skipping operation.")
+			return false, errNoTexture
+		}
+	}
 
-	figureWidth := bounds.Dx() / 2
-	figureHeight := bounds.Dy() / 2
-	if figureWidth < 20 {
-		figureWidth = 20
+	history := l.state.History
+	var undo step
+	var prepare func(op Operation)
+	if history != nil && history.Depth > 0 {
+		prepare = func(op Operation) { undo = l.undoStep(op) }
 	}
-	if figureHeight < 20 {
-		figureHeight = 20
+	version := l.state.version
+	l.state.err = nil
+	op, updateRequested, applied := l.intercept(op, prepare)
+	if !applied {
+		return false, l.state.err
+	}
+	if l.state.version != version {
+		l.dirty = true
+		if history != nil && undo != nil {
+			history.record(op, undo)
+		}
+		if l.Recorder != nil {
+			l.Recorder.Record(op, l.state)
+		}
 	}
 
-	lineWidth := figureHeight / 8
-	if lineWidth < 2 {
-		lineWidth = 2
+	if l.state.Texture.Bounds().Size() != l.state.WindowSize {
+		l.resetTexture()
 	}
 
-	vRect := image.Rect(
-		x-figureWidth/2,
-		y-figureHeight/2,
-		x-figureWidth/2+lineWidth,
-		y+figureHeight/2,
-	)
-	hRect := image.Rect(
-		x-figureWidth/2+lineWidth,
-		y-lineWidth/2,
-		x+figureWidth/2,
-		y+lineWidth/2,
-	)
+	if updateRequested {
+		l.requestFrame()
+	}
+	return updateRequested, l.state.err
+}
 
-	figureColor := color.RGBA{R: 0xff, G: 0xff, B: 0x00, A: 0xff}
+// undoStep returns the step that will undo op, taken before op is applied.
+func (l *Loop) undoStep(op Operation) step {
+	switch op := op.(type) {
+	case UpdateOperation, ResizeOperation, UndoOperation, RedoOperation:
+		return nil
+	case FigureOperation, BgRectOperation:
+		return appendStep{figures: len(l.state.Figures), bgRects: len(l.state.BgRects)}
+	case MoveOperation:
+		return placesOf(l.state, op.Target)
+	case ShiftOperation:
+		return placesOf(l.state, op.Target)
+	default:
+		return sceneStep(l.sceneBefore())
+	}
+}
 
-	texture.Fill(vRect, figureColor, screen.Src)
-	texture.Fill(hRect, figureColor, screen.Src)
+// sceneBefore returns a copy of the current scene. The copy is only taken
+// again once the scene has changed, so operations that leave the scene
+// alone cost no copy.
+func (l *Loop) sceneBefore() Scene {
+	if l.scene == nil || l.sceneVersion != l.state.version {
+		sc := l.state.Scene()
+		l.scene, l.sceneVersion = &sc, l.state.version
+	}
+	return *l.scene
 }
 
-func (l *Loop) Start() {
-	log.Println("Painter loop started")
-	defer close(l.stopped)
+// requestFrame presents a frame now or, if the last one was presented less
+// than frameInterval ago, schedules one for when the interval has passed.
+func (l *Loop) requestFrame() {
+	if l.frameDue != nil {
+		return
+	}
+	if wait := l.frameInterval - l.clock.Now().Sub(l.lastFrame); wait > 0 {
+		l.frameDue = l.clock.After(wait)
+		return
+	}
+	l.present()
+}
 
-	for {
-		select {
-		case <-l.stop:
-			log.Println("Painter loop stopping...")
-			if l.State.Texture != nil {
-				l.State.Texture.Release()
-				l.State.Texture = nil
-			}
-			return
-		case op := <-l.MsgQueue:
-			if l.State.Texture == nil && l.State.Screen != nil {
-				log.Println("Warning: Texture was nil in loop, attempting recreate.")
-				l.resetTexture()
-				if l.State.Texture == nil {
-					log.Println("Error: Failed to recreate texture, skipping operation.")
-					continue
-				}
-			}
+// present draws the scene if needed and hands the texture to the receivers.
+// The acks waiting for the frame resolve after that.
+func (l *Loop) present() {
+	l.frameDue = nil
+	defer l.resolvePending()
+	if l.state.Texture == nil {
+		return
+	}
+	l.render()
+	l.lastFrame = l.clock.Now()
+	if l.Receiver != nil {
+		l.Receiver.Update(l.state.Texture)
+	}
+	l.fanout.publish(l.swap)
+}
 
-			updateRequested := op.Do(l.State)
+// Apply runs ops on the state on the calling goroutine and returns the
+// resulting scene, which is drawn with the next frame. It bypasses the
+// Recorder and is meant for restoring a scene before Start.
+func (l *Loop) Apply(ops []Operation) Scene {
+	for _, op := range ops {
+		op.Do(l.state)
+	}
+	if l.state.Texture == nil || l.state.Texture.Bounds().Size() != l.state.WindowSize {
+		l.resetTexture()
+	}
+	l.dirty = true
+	return l.state.Scene()
+}
 
-			if _, isUpdate := op.(UpdateOperation); !isUpdate {
-				l.drawCurrentState()
-			}
+// Snapshot is a copy of the loop state. It shares no memory with the loop
+// and can be kept and read on any goroutine.
+type Snapshot struct {
+	Scene
+	// Size is the canvas size in pixels.
+	Size image.Point
+}
 
-			if updateRequested && l.Receiver != nil && l.State.Texture != nil {
-				l.Receiver.Update(l.State.Texture)
-			}
+// Snapshot copies the state on the loop goroutine, between operations, so
+// the copy is always consistent. It fails if the loop stops or ctx ends
+// first.
+func (l *Loop) Snapshot(ctx context.Context) (Snapshot, error) {
+	var snap Snapshot
+	err := l.Read(ctx, func(state *LoopState) {
+		snap = Snapshot{Scene: state.Scene(), Size: state.WindowSize}
+	})
+	if err != nil {
+		return Snapshot{}, err
+	}
+	return snap, nil
+}
+
+// readOperation is a function run by Read. The loop runs it outside the
+// interceptor chain and does not record or render anything for it.
+type readOperation func(state *LoopState)
+
+func (o readOperation) Do(state *LoopState) bool {
+	o(state)
+	return false
+}
+
+// Read runs fn on the loop goroutine, between operations, and waits for it
+// to finish. fn must only read the state. Reads are not operations: they
+// are not seen by interceptors and never vetoed. Read fails if the loop
+// stops or ctx ends first; fn may then still run later.
+func (l *Loop) Read(ctx context.Context, fn func(state *LoopState)) error {
+	ack := l.PostAckContext(ctx, readOperation(fn))
+	select {
+	case <-ack.Done():
+		return ack.Err()
+	case <-l.stopped:
+		select {
+		case <-ack.Done():
+			return ack.Err()
+		default:
+			return ErrStopped
 		}
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
@@ -189,12 +571,83 @@ func (l *Loop) Stop() {
 	log.Println("Painter loop stopped.")
 }
 
-func (l *Loop) Post(op Operation) {
+var (
+	// ErrQueueFull is returned for an operation dropped because the message
+	// queue of the loop was full.
+	ErrQueueFull = errors.New("painter: message queue full, operation dropped")
+	// ErrStopped is returned when posting to a loop that has been stopped.
+	ErrStopped = errors.New("painter: loop stopped")
+)
+
+// Post queues op without blocking. If the queue is full, op is dropped and
+// ErrQueueFull is returned; if the loop has stopped, ErrStopped.
+func (l *Loop) Post(op Operation) error {
+	select {
+	case <-l.stop:
+		return ErrStopped
+	default:
+	}
 	select {
 	case l.MsgQueue <- op:
+		return nil
 	default:
 		log.Println("Warning: Painter message queue full. Operation dropped.")
+		return ErrQueueFull
+	}
+}
+
+// PostContext queues op, waiting for room in the queue until ctx ends or the
+// loop stops.
+func (l *Loop) PostContext(ctx context.Context, op Operation) error {
+	// Check first: once the loop has stopped the queue may have room, and
+	// select would pick the send at random. Post does the same.
+	select {
+	case <-l.stop:
+		return ErrStopped
+	default:
+	}
+	select {
+	case l.MsgQueue <- op:
+		return nil
+	case <-l.stop:
+		return ErrStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// PostAckContext posts op like PostContext and returns an Ack that resolves
+// once op has been applied and, if it requested an update, the frame
+// presented. If op cannot be queued, the Ack resolves at once with the error.
+// If ctx ends while op is queued, op is skipped and the Ack resolves with
+// the context error, so a caller that gave up never sees op applied later.
+func (l *Loop) PostAckContext(ctx context.Context, op Operation) *Ack {
+	ack := &Ack{done: make(chan struct{})}
+	if err := l.PostContext(ctx, ackedOperation{op: op, ack: ack, ctx: ctx}); err != nil {
+		ack.resolve(err)
+	}
+	return ack
+}
+
+// TryPostAckContext posts op like PostAck, without waiting for room in the
+// queue, and skips it like PostAckContext if ctx ends while it is queued.
+func (l *Loop) TryPostAckContext(ctx context.Context, op Operation) *Ack {
+	ack := &Ack{done: make(chan struct{})}
+	if err := l.Post(ackedOperation{op: op, ack: ack, ctx: ctx}); err != nil {
+		ack.resolve(err)
+	}
+	return ack
+}
+
+// PostAck posts op like Post and returns an Ack that resolves once op has
+// been applied and, if it requested an update, the frame presented. If op cannot be queued, it is
+// dropped and the Ack resolves at once with ErrQueueFull or ErrStopped.
+func (l *Loop) PostAck(op Operation) *Ack {
+	ack := &Ack{done: make(chan struct{})}
+	if err := l.Post(ackedOperation{op: op, ack: ack}); err != nil {
+		ack.resolve(err)
 	}
+	return ack
 }
 
 func (l *Loop) SetReceiver(r Receiver) {
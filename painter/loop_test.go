@@ -1,6 +1,8 @@
 package painter
 
 import (
+	"context"
+	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
@@ -68,6 +70,19 @@ func (m *MockReceiver) Update(t screen.Texture) {
 	m.Called(t)
 }
 
+// newTestLoop returns a loop drawing into a mocked 800x800 texture. It is
+// not started; tests step it or start it themselves.
+func newTestLoop(t *testing.T, opts ...LoopOption) *Loop {
+	t.Helper()
+	mockScreen := new(MockScreen)
+	mockTexture := new(MockTexture)
+	mockTexture.On("Bounds").Return(image.Rect(0, 0, 800, 800))
+	mockTexture.On("Fill", mock.Anything, mock.Anything, mock.Anything).Return()
+	mockTexture.On("Release").Maybe()
+	mockScreen.On("NewTexture", image.Pt(800, 800)).Return(mockTexture, nil)
+	return NewLoop(mockScreen, opts...)
+}
+
 func TestLoop_Initialization(t *testing.T) {
 	mockScreen := new(MockScreen)
 	mockTexture := new(MockTexture)
@@ -84,16 +99,16 @@ func TestLoop_Initialization(t *testing.T) {
 	l := NewLoop(mockScreen)
 
 	require.NotNil(t, l)
-	require.NotNil(t, l.State)
-	assert.NotNil(t, l.State.Texture)
-	assert.Equal(t, initialBgColor, l.State.Background)
-	assert.Len(t, l.State.Figures, 1)
-	if len(l.State.Figures) == 1 {
-		assert.Equal(t, 400, l.State.Figures[0].X)
-		assert.Equal(t, 400, l.State.Figures[0].Y)
+	require.NotNil(t, l.state)
+	assert.NotNil(t, l.state.Texture)
+	assert.Equal(t, initialBgColor, l.state.Background)
+	assert.Len(t, l.state.Figures, 1)
+	if len(l.state.Figures) == 1 {
+		assert.Equal(t, 0.5, l.state.Figures[0].X)
+		assert.Equal(t, 0.5, l.state.Figures[0].Y)
 	}
-	assert.Nil(t, l.State.BgRect)
-	assert.Equal(t, size, l.State.WindowSize)
+	assert.Empty(t, l.state.BgRects)
+	assert.Equal(t, size, l.state.WindowSize)
 
 	mockScreen.AssertExpectations(t)
 	mockTexture.AssertExpectations(t)
@@ -114,25 +129,34 @@ func TestLoop_PostAndProcess(t *testing.T) {
 
 	l := NewLoop(mockScreen)
 	l.SetReceiver(mockReceiver)
-	go l.Start()
-	time.Sleep(50 * time.Millisecond)
+	require.True(t, l.Step(), "SetReceiver queues an update")
 
 	l.Post(GreenOperation{})
 	l.Post(FigureOperation{X: 0.5, Y: 0.5})
 	l.Post(UpdateOperation{})
-	time.Sleep(100 * time.Millisecond)
+	require.True(t, l.Step())
+	require.Len(t, l.state.Figures, 1, "Step applies one operation at a time")
+	require.NoError(t, l.Drain(t.Context()))
+	assert.False(t, l.Step())
 
 	expectedBg := color.RGBA{G: 0xff, A: 0xff}
-	assert.Equal(t, expectedBg, l.State.Background)
+	assert.Equal(t, expectedBg, l.state.Background)
 
-	require.Len(t, l.State.Figures, 2)
-	assert.Equal(t, 400, l.State.Figures[1].X)
-	assert.Equal(t, 400, l.State.Figures[1].Y)
+	require.Len(t, l.state.Figures, 2)
+	assert.Equal(t, 0.5, l.state.Figures[1].X)
+	assert.Equal(t, 0.5, l.state.Figures[1].Y)
 
 	mockReceiver.AssertExpectations(t)
+	mockScreen.AssertExpectations(t)
 
+	l.Post(WhiteOperation{})
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	assert.ErrorIs(t, l.Drain(ctx), context.Canceled)
+	assert.Len(t, l.MsgQueue, 1)
+
+	go l.Start()
 	l.Stop()
-	mockScreen.AssertExpectations(t)
 	mockTexture.AssertCalled(t, "Release")
 }
 
@@ -152,24 +176,25 @@ func TestLoop_ResetOperation(t *testing.T) {
 	l := NewLoop(mockScreen)
 	l.SetReceiver(mockReceiver)
 	go l.Start()
-	time.Sleep(50 * time.Millisecond)
 
 	l.Post(GreenOperation{})
 	l.Post(FigureOperation{X: 0.2, Y: 0.2})
 	l.Post(BgRectOperation{X1: 0.1, Y1: 0.1, X2: 0.9, Y2: 0.9})
-	time.Sleep(50 * time.Millisecond)
+	snap, err := l.Snapshot(t.Context())
+	require.NoError(t, err)
 
-	require.NotEqual(t, color.Black, l.State.Background)
-	require.NotEmpty(t, l.State.Figures)
-	require.NotNil(t, l.State.BgRect)
+	require.NotEqual(t, color.Black, snap.Background)
+	require.NotEmpty(t, snap.Figures)
+	require.Len(t, snap.BgRects, 1)
 
 	l.Post(ResetOperation{})
 	l.Post(UpdateOperation{})
-	time.Sleep(100 * time.Millisecond)
+	snap, err = l.Snapshot(t.Context())
+	require.NoError(t, err)
 
-	assert.Equal(t, color.Black, l.State.Background)
-	assert.Empty(t, l.State.Figures)
-	assert.Nil(t, l.State.BgRect)
+	assert.Equal(t, color.Black, snap.Background)
+	assert.Empty(t, snap.Figures)
+	assert.Empty(t, snap.BgRects)
 
 	mockReceiver.AssertExpectations(t)
 
@@ -177,3 +202,453 @@ func TestLoop_ResetOperation(t *testing.T) {
 	mockScreen.AssertExpectations(t)
 	mockTexture.AssertCalled(t, "Release")
 }
+
+func TestBgRectOperations(t *testing.T) {
+	state := &LoopState{}
+	translucent := color.NRGBA{R: 0xff, A: 0x80}
+
+	BgRectOperation{X1: 0.1, Y1: 0.1, X2: 0.5, Y2: 0.5}.Do(state)
+	BgRectOperation{X1: 0.2, Y1: 0.2, X2: 0.6, Y2: 0.6, Color: translucent}.Do(state)
+	BgRectOperation{X1: 0.3, Y1: 0.3, X2: 0.7, Y2: 0.7}.Do(state)
+	require.Len(t, state.BgRects, 3)
+	assert.Equal(t, color.Black, state.BgRects[0].Color)
+	assert.Equal(t, translucent, state.BgRects[1].Color)
+
+	BgPopOperation{}.Do(state)
+	require.Len(t, state.BgRects, 2)
+	assert.InDelta(t, 0.2, state.BgRects[1].X1, 0.001)
+
+	BgClearOperation{}.Do(state)
+	assert.Empty(t, state.BgRects)
+	BgPopOperation{}.Do(state)
+	assert.Empty(t, state.BgRects)
+}
+
+func TestBatchOperation(t *testing.T) {
+	state := &LoopState{Background: color.Black, WindowSize: image.Pt(800, 800)}
+
+	update := BatchOperation{Ops: []Operation{
+		WhiteOperation{},
+		FigureOperation{X: 0.2, Y: 0.2, ID: "a"},
+		UpdateOperation{},
+		MoveOperation{X: 0.4, Y: 0.4, Target: Target{ID: "a"}},
+	}}.Do(state)
+	assert.True(t, update)
+	assert.NoError(t, state.err)
+	assert.Equal(t, color.White, state.Background)
+	require.Len(t, state.Figures, 1)
+	assert.Equal(t, 0.4, state.Figures[0].X)
+
+	before := state.Scene()
+	update = BatchOperation{Ops: []Operation{
+		GreenOperation{},
+		ResizeOperation{Size: image.Pt(100, 100)},
+		BgRectOperation{X1: 0.1, Y1: 0.1, X2: 0.5, Y2: 0.5},
+		UpdateOperation{},
+		FigureOperation{X: 0.5, Y: 0.5, ID: "a"},
+		ShiftOperation{DX: 0.1},
+	}}.Do(state)
+	assert.False(t, update)
+	assert.ErrorContains(t, state.err, "rolled back at operation 5")
+	assert.Equal(t, before, state.Scene())
+	assert.Equal(t, image.Pt(800, 800), state.WindowSize)
+}
+
+func TestLoop_DrawBgRectsInOrder(t *testing.T) {
+	mockScreen := new(MockScreen)
+	mockTexture := new(MockTexture)
+	size := image.Point{X: 800, Y: 800}
+	translucent := color.NRGBA{B: 0xff, A: 0x80}
+
+	mockTexture.On("Bounds").Return(image.Rectangle{Max: size})
+	mockTexture.On("Fill", mock.Anything, mock.Anything, mock.Anything).Return()
+	mockScreen.On("NewTexture", size).Return(mockTexture, nil).Once()
+
+	l := NewLoop(mockScreen)
+	l.state.Figures = nil
+	l.state.BgRects = []BgRect{
+		{RelativeRectangle: RelativeRectangle{X2: 0.5, Y2: 0.5}, Color: color.Black},
+		{RelativeRectangle: RelativeRectangle{X1: 0.25, Y1: 0.25, X2: 1, Y2: 1}, Color: translucent},
+	}
+	mockTexture.Calls = nil
+	l.drawCurrentState()
+
+	var rectFills []mock.Call
+	for _, c := range mockTexture.Calls {
+		if c.Method == "Fill" {
+			rectFills = append(rectFills, c)
+		}
+	}
+	require.Len(t, rectFills, 3)
+	assert.Equal(t, image.Rect(0, 0, 400, 400), rectFills[1].Arguments[0])
+	assert.Equal(t, draw.Src, rectFills[1].Arguments[2])
+	assert.Equal(t, image.Rect(200, 200, 800, 800), rectFills[2].Arguments[0])
+	assert.Equal(t, translucent, rectFills[2].Arguments[1])
+	assert.Equal(t, draw.Over, rectFills[2].Arguments[2])
+}
+
+func TestMoveOperation_Target(t *testing.T) {
+	state := &LoopState{}
+
+	FigureOperation{X: 0.1, Y: 0.1, ID: "a"}.Do(state)
+	FigureOperation{X: 0.2, Y: 0.2, ID: "b", Tags: []string{"top"}}.Do(state)
+	FigureOperation{X: 0.3, Y: 0.3, Tags: []string{"top"}}.Do(state)
+	FigureOperation{X: 0.9, Y: 0.9, ID: "a"}.Do(state)
+	require.Len(t, state.Figures, 3)
+
+	MoveOperation{X: 0.5, Y: 0.5, Target: Target{ID: "a"}}.Do(state)
+	assert.Equal(t, 0.5, state.Figures[0].X)
+	assert.Equal(t, 0.2, state.Figures[1].X)
+	assert.Equal(t, 0.3, state.Figures[2].X)
+
+	MoveOperation{X: 0.7, Y: 0.7, Target: Target{Tag: "top"}}.Do(state)
+	assert.Equal(t, 0.5, state.Figures[0].X)
+	assert.Equal(t, 0.7, state.Figures[1].X)
+	assert.Equal(t, 0.7, state.Figures[2].X)
+
+	MoveOperation{X: 0.1, Y: 0.1, Target: Target{ID: "missing"}}.Do(state)
+	MoveOperation{X: 0.0, Y: 0.0}.Do(state)
+	for _, f := range state.Figures {
+		assert.Equal(t, 0.0, f.X)
+		assert.Equal(t, 0.0, f.Y)
+	}
+}
+
+func TestShiftAndMoveKeepLayout(t *testing.T) {
+	state := &LoopState{}
+
+	FigureOperation{X: 0.1, Y: 0.1, Tags: []string{"row"}}.Do(state)
+	FigureOperation{X: 0.3, Y: 0.1, Tags: []string{"row"}}.Do(state)
+	FigureOperation{X: 0.9, Y: 0.9}.Do(state)
+
+	ShiftOperation{DX: 0.1, DY: 0.2, Target: Target{Tag: "row"}}.Do(state)
+	assert.InDeltaSlice(t, []float64{0.2, 0.3, 0.4, 0.3}, []float64{state.Figures[0].X, state.Figures[0].Y, state.Figures[1].X, state.Figures[1].Y}, 1e-9)
+	assert.Equal(t, 0.9, state.Figures[2].X)
+
+	MoveOperation{X: 0.5, Y: 0.5, Target: Target{Tag: "row"}, KeepLayout: true}.Do(state)
+	assert.InDeltaSlice(t, []float64{0.4, 0.5, 0.6, 0.5}, []float64{state.Figures[0].X, state.Figures[0].Y, state.Figures[1].X, state.Figures[1].Y}, 1e-9)
+	assert.Equal(t, 0.9, state.Figures[2].X)
+}
+
+func TestLoop_FiguresFollowTextureSize(t *testing.T) {
+	figureColor := color.RGBA{R: 0xff, G: 0xff, B: 0x00, A: 0xff}
+	figureFills := func(size image.Point) []image.Rectangle {
+		mockScreen := new(MockScreen)
+		mockTexture := new(MockTexture)
+		mockTexture.On("Bounds").Return(image.Rectangle{Max: size})
+		mockTexture.On("Fill", mock.Anything, mock.Anything, mock.Anything).Return()
+		mockScreen.On("NewTexture", mock.Anything).Return(mockTexture, nil)
+
+		l := NewLoop(mockScreen)
+		l.state.Figures = []*Figure{{X: 0.25, Y: 0.75}}
+		mockTexture.Calls = nil
+		l.drawCurrentState()
+
+		var rects []image.Rectangle
+		for _, c := range mockTexture.Calls {
+			if c.Method == "Fill" && c.Arguments[1] == figureColor {
+				rects = append(rects, c.Arguments[0].(image.Rectangle))
+			}
+		}
+		return rects
+	}
+
+	small := figureFills(image.Point{X: 400, Y: 400})
+	large := figureFills(image.Point{X: 800, Y: 800})
+	require.Len(t, small, 2)
+	require.Len(t, large, 2)
+	for i := range small {
+		assert.InDelta(t, small[i].Min.X*2, large[i].Min.X, 2)
+		assert.InDelta(t, small[i].Min.Y*2, large[i].Min.Y, 2)
+		assert.InDelta(t, small[i].Max.X*2, large[i].Max.X, 2)
+		assert.InDelta(t, small[i].Max.Y*2, large[i].Max.Y, 2)
+	}
+}
+
+func TestLoop_ResizeReallocatesTexture(t *testing.T) {
+	mockScreen := new(MockScreen)
+	mockReceiver := new(MockReceiver)
+	oldTexture := new(MockTexture)
+	newTexture := new(MockTexture)
+	oldSize := image.Point{X: 800, Y: 800}
+	newSize := image.Point{X: 1024, Y: 600}
+
+	for tex, size := range map[*MockTexture]image.Point{oldTexture: oldSize, newTexture: newSize} {
+		tex.On("Release").Maybe()
+		tex.On("Bounds").Return(image.Rectangle{Max: size})
+		tex.On("Fill", mock.AnythingOfType("image.Rectangle"), mock.Anything, mock.Anything).Return().Maybe()
+	}
+	mockScreen.On("NewTexture", oldSize).Return(oldTexture, nil).Once()
+	mockScreen.On("NewTexture", newSize).Return(newTexture, nil).Once()
+	mockReceiver.On("Update", newTexture).Return().Once()
+
+	l := NewLoop(mockScreen)
+	l.Receiver = mockReceiver
+	go l.Start()
+
+	l.Post(ResizeOperation{Size: newSize})
+	l.Post(ResizeOperation{Size: image.Point{}})
+	snap, err := l.Snapshot(t.Context())
+	require.NoError(t, err)
+
+	assert.Equal(t, newSize, snap.Size)
+	var kept screen.Texture
+	require.NoError(t, l.PostAck(OperationFunc(func(state *LoopState) bool {
+		kept = state.Texture
+		return false
+	})).Wait(t.Context()))
+	assert.Same(t, newTexture, kept, "the loop keeps the reallocated texture")
+	oldTexture.AssertCalled(t, "Release")
+	newTexture.AssertCalled(t, "Fill", image.Rectangle{Max: newSize}, snap.Background, draw.Src)
+
+	l.Stop()
+	mockScreen.AssertExpectations(t)
+	mockReceiver.AssertExpectations(t)
+}
+
+func TestLoop_PostAck(t *testing.T) {
+	mockReceiver := new(MockReceiver)
+	mockReceiver.On("Update", mock.Anything).Return()
+
+	l := newTestLoop(t)
+	l.Receiver = mockReceiver
+	go l.Start()
+	defer l.Stop()
+
+	ack := l.PostAck(UpdateOperation{})
+	require.NoError(t, ack.Wait(t.Context()))
+	mockReceiver.AssertNumberOfCalls(t, "Update", 1)
+
+	l.Post(FigureOperation{X: 0.1, Y: 0.1, ID: "a"})
+	err := l.PostAck(FigureOperation{X: 0.2, Y: 0.2, ID: "a"}).Wait(t.Context())
+	assert.ErrorContains(t, err, "already used")
+	assert.NoError(t, l.PostAck(WhiteOperation{}).Wait(t.Context()), "failures do not leak into later operations")
+}
+
+func TestLoop_PostContext(t *testing.T) {
+	l := newTestLoop(t, WithQueueCapacity(2))
+	require.Equal(t, 2, cap(l.MsgQueue))
+	require.NoError(t, l.Post(WhiteOperation{}))
+	require.NoError(t, l.Post(GreenOperation{}))
+	assert.ErrorIs(t, l.Post(UpdateOperation{}), ErrQueueFull)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, l.PostContext(ctx, UpdateOperation{}), context.DeadlineExceeded)
+
+	posted := make(chan error)
+	go func() { posted <- l.PostContext(t.Context(), UpdateOperation{}) }()
+	go l.Start()
+	require.NoError(t, <-posted, "waits until the loop makes room")
+
+	l.Stop()
+	for len(l.MsgQueue) < cap(l.MsgQueue) {
+		l.MsgQueue <- UpdateOperation{}
+	}
+	assert.ErrorIs(t, l.PostContext(t.Context(), UpdateOperation{}), ErrStopped)
+}
+
+func TestLoop_PostContextAfterStop(t *testing.T) {
+	l := newTestLoop(t)
+	go l.Start()
+	l.Stop()
+
+	for i := 0; i < 100; i++ {
+		require.ErrorIs(t, l.PostContext(t.Context(), UpdateOperation{}), ErrStopped)
+		require.ErrorIs(t, l.Post(UpdateOperation{}), ErrStopped)
+	}
+	assert.Empty(t, l.MsgQueue, "nothing is queued on a stopped loop")
+	assert.ErrorIs(t, l.PostAckContext(t.Context(), UpdateOperation{}).Err(), ErrStopped)
+	assert.ErrorIs(t, l.PostAck(UpdateOperation{}).Err(), ErrStopped, "the ack resolves at once")
+}
+
+func TestLoop_ManualClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	l := newTestLoop(t, WithClock(clock))
+	assert.Same(t, clock, l.clock)
+
+	var seen []time.Time
+	for i := 0; i < 3; i++ {
+		l.Post(OperationFunc(func(*LoopState) bool {
+			seen = append(seen, l.clock.Now())
+			clock.Advance(time.Second)
+			return false
+		}))
+	}
+	require.NoError(t, l.Drain(t.Context()))
+	assert.Equal(t, []time.Time{start, start.Add(time.Second), start.Add(2 * time.Second)}, seen)
+}
+
+func TestLoop_CoalescesRepaints(t *testing.T) {
+	s := new(countingScreen)
+	l := NewLoop(s, WithQueueCapacity(1000))
+	frames := make(frameReceiver, 10)
+	l.Receiver = frames
+	require.Equal(t, int32(1), s.repaints.Load(), "the first frame is drawn up front")
+
+	for i := 0; i < 999; i++ {
+		l.Post(ShiftOperation{DX: 0.001})
+	}
+	require.NoError(t, l.Drain(t.Context()))
+	assert.Equal(t, int32(1), s.repaints.Load(), "operations only mark the scene dirty")
+	assert.Empty(t, frames)
+
+	updates(t, l, 1)
+	<-frames
+	assert.Equal(t, int32(2), s.repaints.Load())
+
+	updates(t, l, 1)
+	<-frames
+	assert.Equal(t, int32(2), s.repaints.Load(), "an unchanged scene is not redrawn")
+}
+
+func TestLoop_MaxFPS(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	s := new(countingScreen)
+	l := NewLoop(s, WithClock(clock), WithMaxFPS(10))
+	frames := make(frameReceiver, 10)
+	l.Receiver = frames
+
+	updates(t, l, 1)
+	<-frames
+
+	clock.Advance(50 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		l.Post(ShiftOperation{DX: 0.1})
+		l.Post(UpdateOperation{})
+	}
+	white := l.PostAck(WhiteOperation{})
+	update := l.PostAck(UpdateOperation{})
+	require.NoError(t, l.Drain(t.Context()))
+	assert.Empty(t, frames, "updates within the interval are held back")
+	assert.Equal(t, 1, clock.Waiters())
+	assert.NoError(t, white.Wait(t.Context()), "operations without an update resolve at once")
+	select {
+	case <-update.Done():
+		t.Fatal("the ack resolved before its frame was presented")
+	default:
+	}
+
+	clock.Advance(50 * time.Millisecond)
+	require.NoError(t, l.Drain(t.Context()))
+	<-frames
+	assert.NoError(t, update.Wait(t.Context()))
+	assert.Empty(t, frames, "held back updates are coalesced into one frame")
+	assert.Equal(t, int32(2), s.repaints.Load())
+	assert.InDelta(t, 0.8, l.state.Figures[0].X, 1e-9)
+
+	clock.Advance(time.Second)
+	updates(t, l, 1)
+	<-frames
+}
+
+// scripts build the workloads of TestLoop_ScriptRepaintsOnce and
+// BenchmarkLoop_Script: n operations followed by an update.
+var scripts = map[string]func(n int) []Operation{
+	"moves": func(n int) []Operation {
+		var ops []Operation
+		for i := 0; i < n; i++ {
+			ops = append(ops, MoveOperation{X: float64(i%100) / 100, Y: 0.5})
+		}
+		return append(ops, UpdateOperation{})
+	},
+	"figures": func(n int) []Operation {
+		ops := []Operation{ResetOperation{}}
+		for i := 1; i < n; i++ {
+			ops = append(ops, FigureOperation{X: float64(i%100) / 100, Y: 0.5})
+		}
+		return append(ops, UpdateOperation{})
+	},
+}
+
+// runScript posts ops and drains the loop.
+func runScript(tb testing.TB, l *Loop, ops []Operation) {
+	for _, op := range ops {
+		if err := l.Post(op); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	if err := l.Drain(context.Background()); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func TestLoop_ScriptRepaintsOnce(t *testing.T) {
+	for name, script := range scripts {
+		t.Run(name, func(t *testing.T) {
+			s := new(countingScreen)
+			ops := script(1000)
+			l := NewLoop(s, WithQueueCapacity(len(ops)))
+			var presented int32
+			l.Receiver = receiverFunc(func(screen.Texture) { presented++ })
+			s.repaints.Store(0)
+
+			for i := 0; i < 3; i++ {
+				runScript(t, l, ops)
+			}
+			assert.Equal(t, int32(3), presented)
+			assert.Equal(t, presented, s.repaints.Load(), "one repaint per presented frame")
+			assert.True(t, l.state.History.CanUndo(), "the history is enabled")
+		})
+	}
+}
+
+// BenchmarkLoop_Script applies the scripts with the undo history enabled and
+// reports the cost per operation and the repaints per presented frame.
+func BenchmarkLoop_Script(b *testing.B) {
+	for _, name := range []string{"moves", "figures"} {
+		for _, n := range []int{100, 1000, 10000} {
+			b.Run(fmt.Sprintf("%s/%d", name, n), func(b *testing.B) {
+				ops := scripts[name](n)
+				s := new(countingScreen)
+				l := NewLoop(s, WithQueueCapacity(len(ops)))
+				var presented int
+				l.Receiver = receiverFunc(func(screen.Texture) { presented++ })
+				s.repaints.Store(0)
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					runScript(b, l, ops)
+				}
+				b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*len(ops)), "ns/operation")
+				b.ReportMetric(float64(s.repaints.Load())/float64(presented), "repaints/frame")
+			})
+		}
+	}
+}
+
+type receiverFunc func(screen.Texture)
+
+func (f receiverFunc) Update(t screen.Texture) { f(t) }
+
+type recorderFunc func(op Operation, state *LoopState)
+
+func (f recorderFunc) Record(op Operation, state *LoopState) { f(op, state) }
+
+func TestLoop_RecordsOnlyChanges(t *testing.T) {
+	l := newTestLoop(t)
+	var recorded []Operation
+	l.Recorder = recorderFunc(func(op Operation, _ *LoopState) { recorded = append(recorded, op) })
+
+	for _, op := range []Operation{
+		UpdateOperation{},
+		MoveOperation{X: 0.1, Y: 0.1, Target: Target{ID: "missing"}},
+		GreenOperation{},
+		BgPopOperation{},
+		WhiteOperation{},
+		WhiteOperation{},
+		OperationFunc(func(*LoopState) bool { return false }),
+		FigureOperation{X: 0.2, Y: 0.2},
+	} {
+		l.Post(op)
+	}
+	require.NoError(t, l.Drain(t.Context()))
+	assert.Equal(t, []Operation{WhiteOperation{}, FigureOperation{X: 0.2, Y: 0.2}}, recorded)
+
+	l.Post(UndoOperation{})
+	l.Post(UndoOperation{})
+	require.NoError(t, l.Drain(t.Context()))
+	assert.Equal(t, color.RGBA{G: 0xff, A: 0xff}, l.state.Background, "each change is one undo step")
+	assert.False(t, l.state.History.CanUndo())
+}
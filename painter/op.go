@@ -1,27 +1,59 @@
 package painter
 
 import (
+	"errors"
+	"fmt"
+	"image"
 	"image/color"
 	"log"
+	"math"
 	"strconv"
+	"strings"
 )
 
+// OperationFunc adapts a function to the Operation interface. It is the way
+// to run code with access to the state on the loop goroutine. A function
+// that changes the scene must call LoopState.Changed.
+type OperationFunc func(state *LoopState) bool
+
+func (f OperationFunc) Do(state *LoopState) bool {
+	return f(state)
+}
+
 type WhiteOperation struct{}
 
 func (o WhiteOperation) Do(state *LoopState) bool {
-	state.Background = color.White
+	state.setBackground(color.White)
 	log.Println("Background set to white")
 	return false
 }
 
+func (o WhiteOperation) String() string { return "white" }
+
 type GreenOperation struct{}
 
 func (o GreenOperation) Do(state *LoopState) bool {
-	state.Background = color.RGBA{G: 0xff, A: 0xff}
+	state.setBackground(color.RGBA{G: 0xff, A: 0xff})
 	log.Println("Background set to green")
 	return false
 }
 
+func (o GreenOperation) String() string { return "green" }
+
+type BackgroundOperation struct {
+	Color color.Color
+}
+
+func (o BackgroundOperation) Do(state *LoopState) bool {
+	state.setBackground(o.Color)
+	log.Printf("Background set to %v", o.Color)
+	return false
+}
+
+func (o BackgroundOperation) String() string {
+	return "bg " + hexOrBlack(o.Color)
+}
+
 type UpdateOperation struct{}
 
 func (o UpdateOperation) Do(_ *LoopState) bool {
@@ -29,87 +61,303 @@ func (o UpdateOperation) Do(_ *LoopState) bool {
 	return true
 }
 
+func (o UpdateOperation) String() string { return "update" }
+
+// BgRectOperation adds a background rectangle on top of the ones already
+// present. A nil Color draws the rectangle black.
 type BgRectOperation struct {
 	X1, Y1, X2, Y2 float64
+	Color          color.Color
 }
 
 func (o BgRectOperation) Do(state *LoopState) bool {
-	state.BgRect = &RelativeRectangle{
-		X1: o.X1, Y1: o.Y1, X2: o.X2, Y2: o.Y2,
+	c := o.Color
+	if c == nil {
+		c = color.Black
+	}
+	state.BgRects = append(state.BgRects, BgRect{
+		RelativeRectangle: RelativeRectangle{X1: o.X1, Y1: o.Y1, X2: o.X2, Y2: o.Y2},
+		Color:             c,
+	})
+	state.Changed()
+	log.Printf("Background rectangle #%d added: [%.2f, %.2f] -> [%.2f, %.2f] %v", len(state.BgRects), o.X1, o.Y1, o.X2, o.Y2, c)
+	return false
+}
+
+func (o BgRectOperation) String() string {
+	return fmt.Sprintf("bgrect %s %s %s %s %s", formatFloat(o.X1), formatFloat(o.Y1), formatFloat(o.X2), formatFloat(o.Y2), hexOrBlack(o.Color))
+}
+
+type BgClearOperation struct{}
+
+func (o BgClearOperation) Do(state *LoopState) bool {
+	log.Printf("Removing all %d background rectangles", len(state.BgRects))
+	if len(state.BgRects) > 0 {
+		state.BgRects = nil
+		state.Changed()
+	}
+	return false
+}
+
+func (o BgClearOperation) String() string { return "bgclear" }
+
+type BgPopOperation struct{}
+
+func (o BgPopOperation) Do(state *LoopState) bool {
+	if len(state.BgRects) == 0 {
+		log.Println("No background rectangle to remove")
+		return false
 	}
-	log.Printf("Background rectangle set to: [%.2f, %.2f] -> [%.2f, %.2f]", o.X1, o.Y1, o.X2, o.Y2)
+	state.BgRects = state.BgRects[:len(state.BgRects)-1]
+	state.Changed()
+	log.Printf("Removed last background rectangle, %d left", len(state.BgRects))
 	return false
 }
 
+func (o BgPopOperation) String() string { return "bgpop" }
+
+// FigureOperation adds a figure. ID is optional but must be unique among
+// the current figures when set.
 type FigureOperation struct {
 	X, Y float64
+	ID   string
+	Tags []string
 }
 
 func (o FigureOperation) Do(state *LoopState) bool {
-	if state.Texture == nil {
-		log.Println("Error: Cannot add figure, texture is nil")
+	if o.ID != "" && state.FindFigure(o.ID) != nil {
+		state.Fail(fmt.Errorf("cannot add figure, id '%s' is already used", o.ID))
 		return false
 	}
-	bounds := state.Texture.Bounds()
-	pxX := int(o.X * float64(bounds.Dx()))
-	pxY := int(o.Y * float64(bounds.Dy()))
-	state.Figures = append(state.Figures, &Figure{X: pxX, Y: pxY})
-	log.Printf("Figure added at relative: %.2f, %.2f (pixels: %d, %d)", o.X, o.Y, pxX, pxY)
+	state.Figures = append(state.Figures, &Figure{X: o.X, Y: o.Y, ID: o.ID, Tags: append([]string(nil), o.Tags...)})
+	state.Changed()
+	log.Printf("Figure %q added at relative: %.2f, %.2f", o.ID, o.X, o.Y)
 	return false
 }
 
+func (o FigureOperation) String() string {
+	var b strings.Builder
+	b.WriteString("figure")
+	if o.ID != "" {
+		b.WriteString(" id=" + o.ID)
+	}
+	for _, tag := range o.Tags {
+		b.WriteString(" tag=" + tag)
+	}
+	fmt.Fprintf(&b, " %s %s", formatFloat(o.X), formatFloat(o.Y))
+	return b.String()
+}
+
+// MoveOperation moves the figures selected by Target to the given point.
+// With KeepLayout the centroid of the selection is moved there instead and
+// the figures keep their positions relative to each other.
 type MoveOperation struct {
-	X, Y float64
+	X, Y       float64
+	Target     Target
+	KeepLayout bool
 }
 
 func (o MoveOperation) Do(state *LoopState) bool {
-	if state.Texture == nil {
-		log.Println("Error: Cannot move figures, texture is nil")
+	selected := state.SelectFigures(o.Target)
+	if len(selected) == 0 {
+		log.Printf("Warning: No figures match %s, nothing moved", o.Target)
+		return false
+	}
+	if o.KeepLayout {
+		cx, cy := centroid(selected)
+		translate(selected, o.X-cx, o.Y-cy)
+		state.Changed()
+		log.Printf("Moved centroid of %d figures (%s) to relative: %.2f, %.2f", len(selected), o.Target, o.X, o.Y)
 		return false
 	}
-	bounds := state.Texture.Bounds()
-	newX := int(o.X * float64(bounds.Dx()))
-	newY := int(o.Y * float64(bounds.Dy()))
-	log.Printf("Moving all %d figures to relative: %.2f, %.2f (pixels: %d, %d)", len(state.Figures), o.X, o.Y, newX, newY)
-	for _, f := range state.Figures {
-		f.X = newX
-		f.Y = newY
+	for _, f := range selected {
+		f.X = o.X
+		f.Y = o.Y
 	}
+	state.Changed()
+	log.Printf("Moved %d figures (%s) to relative: %.2f, %.2f", len(selected), o.Target, o.X, o.Y)
 	return false
 }
 
+func (o MoveOperation) String() string {
+	layout := ""
+	if o.KeepLayout {
+		layout = " layout=keep"
+	}
+	return fmt.Sprintf("move %s%s %s %s", o.Target, layout, formatFloat(o.X), formatFloat(o.Y))
+}
+
+// ShiftOperation translates the figures selected by Target by a relative
+// offset, preserving their layout.
+type ShiftOperation struct {
+	DX, DY float64
+	Target Target
+}
+
+func (o ShiftOperation) Do(state *LoopState) bool {
+	selected := state.SelectFigures(o.Target)
+	if len(selected) == 0 {
+		log.Printf("Warning: No figures match %s, nothing shifted", o.Target)
+		return false
+	}
+	translate(selected, o.DX, o.DY)
+	state.Changed()
+	log.Printf("Shifted %d figures (%s) by relative: %.2f, %.2f", len(selected), o.Target, o.DX, o.DY)
+	return false
+}
+
+func (o ShiftOperation) String() string {
+	return fmt.Sprintf("shift %s %s %s", o.Target, formatFloat(o.DX), formatFloat(o.DY))
+}
+
+func centroid(figures []*Figure) (x, y float64) {
+	for _, f := range figures {
+		x += f.X
+		y += f.Y
+	}
+	n := float64(len(figures))
+	return x / n, y / n
+}
+
+func translate(figures []*Figure, dx, dy float64) {
+	for _, f := range figures {
+		f.X += dx
+		f.Y += dy
+	}
+}
+
+// ResizeOperation changes the size of the canvas. The loop reallocates its
+// texture at the new size and re-renders the scene.
+type ResizeOperation struct {
+	Size image.Point
+}
+
+func (o ResizeOperation) Do(state *LoopState) bool {
+	if o.Size.X <= 0 || o.Size.Y <= 0 {
+		state.Fail(fmt.Errorf("invalid canvas size %v, ignoring resize", o.Size))
+		return false
+	}
+	if state.WindowSize == o.Size {
+		return false
+	}
+	log.Printf("Canvas resized from %v to %v", state.WindowSize, o.Size)
+	state.WindowSize = o.Size
+	return true
+}
+
 type ResetOperation struct{}
 
 func (o ResetOperation) Do(state *LoopState) bool {
 	log.Println("Resetting state to default (black background, no figures)")
 	state.Background = color.Black
-	state.BgRect = nil
+	state.BgRects = nil
 	state.Figures = make([]*Figure, 0)
+	state.Changed()
 	return false
 }
 
-func ParseCoords(args []string, count int) ([]float64, bool) {
+func (o ResetOperation) String() string { return "reset" }
+
+// BatchOperation applies Ops as a single step, so observers see the state
+// either before or after all of them. Each operation goes through the
+// interceptors of the loop. If one of them fails or is vetoed, the scene,
+// canvas size and undo history are restored and the batch fails with its
+// error.
+// Update requests are merged into one after the last operation.
+type BatchOperation struct {
+	Ops []Operation
+}
+
+func (o BatchOperation) Do(state *LoopState) bool {
+	before := state.Scene()
+	version := state.version
+	size := state.WindowSize
+	var history History
+	if state.History != nil {
+		history = state.History.clone()
+	}
+	update := false
+	for i, op := range o.Ops {
+		state.err = nil
+		if state.apply(op) {
+			update = true
+		}
+		if err := state.err; err != nil {
+			state.SetScene(before)
+			state.version = version
+			state.WindowSize = size
+			if state.History != nil {
+				*state.History = history
+			}
+			state.Fail(fmt.Errorf("transaction rolled back at operation %d (%v): %w", i+1, op, err))
+			return false
+		}
+	}
+	log.Printf("Transaction of %d operations committed", len(o.Ops))
+	return update
+}
+
+// formatFloat formats v with the fewest digits that parse back to exactly v.
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type CoordError struct {
+	Index int
+	Arg   string
+	Err   error
+}
+
+func (e *CoordError) Error() string {
+	if e.Index < 0 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("coordinate %d (%q): %v", e.Index+1, e.Arg, e.Err)
+}
+
+func (e *CoordError) Unwrap() error { return e.Err }
+
+type ClampWarning struct {
+	Index          int
+	Value, Clamped float64
+}
+
+func (w ClampWarning) String() string {
+	return fmt.Sprintf("coordinate %d clamped from %g to %g", w.Index+1, w.Value, w.Clamped)
+}
+
+// ParseCoords parses count relative coordinates. Values outside [0, 1] are
+// clamped and reported as warnings; malformed input is reported as *CoordError.
+func ParseCoords(args []string, count int) ([]float64, []ClampWarning, error) {
+	return parseRange(args, count, 0, 1)
+}
+
+// ParseOffsets parses count relative offsets, clamped to [-1, 1].
+func ParseOffsets(args []string, count int) ([]float64, []ClampWarning, error) {
+	return parseRange(args, count, -1, 1)
+}
+
+// ParseFloats parses count numbers without clamping them.
+func ParseFloats(args []string, count int) ([]float64, []ClampWarning, error) {
+	return parseRange(args, count, math.Inf(-1), math.Inf(1))
+}
+
+func parseRange(args []string, count int, lo, hi float64) ([]float64, []ClampWarning, error) {
 	if len(args) != count {
-		log.Printf("Error: Expected %d coordinate arguments, got %d: %v", count, len(args), args)
-		return nil, false
+		return nil, nil, &CoordError{Index: -1, Err: fmt.Errorf("expected %d coordinate arguments, got %d", count, len(args))}
 	}
 	coords := make([]float64, count)
-	var err error
+	var warnings []ClampWarning
 	for i, arg := range args {
-		coords[i], err = strconv.ParseFloat(arg, 64)
-		if err != nil {
-			log.Printf("Error: Could not parse coordinate '%s': %v", arg, err)
-			return nil, false
+		v, err := strconv.ParseFloat(arg, 64)
+		if err != nil || math.IsNaN(v) {
+			return nil, nil, &CoordError{Index: i, Arg: arg, Err: errors.New("not a number")}
 		}
-		if coords[i] < 0 || coords[i] > 1 {
-			if coords[i] < 0 {
-				coords[i] = 0
-			}
-			if coords[i] > 1 {
-				coords[i] = 1
-			}
-			log.Printf("Warning: Coordinate clamped to %.3f", coords[i])
+		coords[i] = v
+		if v < lo || v > hi {
+			coords[i] = math.Min(math.Max(v, lo), hi)
+			warnings = append(warnings, ClampWarning{Index: i, Value: v, Clamped: coords[i]})
 		}
 	}
-	return coords, true
+	return coords, warnings, nil
 }
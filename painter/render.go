@@ -0,0 +1,89 @@
+package painter
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+var FigureColor = color.RGBA{R: 0xff, G: 0xff, B: 0x00, A: 0xff}
+
+// canvas is the surface a scene is drawn onto; screen.Texture satisfies it.
+type canvas interface {
+	Bounds() image.Rectangle
+	Fill(dr image.Rectangle, src color.Color, op draw.Op)
+}
+
+func drawState(c canvas, state *LoopState) {
+	bounds := c.Bounds()
+	c.Fill(bounds, state.Background, draw.Src)
+	for _, r := range state.BgRects {
+		op := draw.Src
+		if _, _, _, a := r.Color.RGBA(); a != 0xffff {
+			op = draw.Over
+		}
+		c.Fill(r.Pixels(bounds), r.Color, op)
+	}
+	width, height := float64(bounds.Dx()), float64(bounds.Dy())
+	for _, f := range state.Figures {
+		drawFigure(c, int(f.X*width), int(f.Y*height))
+	}
+}
+
+// Pixels converts the rectangle to pixel coordinates within bounds.
+func (r RelativeRectangle) Pixels(bounds image.Rectangle) image.Rectangle {
+	width, height := float64(bounds.Dx()), float64(bounds.Dy())
+	return image.Rect(
+		int(r.X1*width), int(r.Y1*height),
+		int(r.X2*width), int(r.Y2*height),
+	)
+}
+
+// FigureBars returns the vertical and horizontal bars of a T-shaped figure
+// centered at x, y on a canvas with the given bounds. The figure is sized
+// from the shorter side, so it keeps its shape on any canvas.
+func FigureBars(bounds image.Rectangle, x, y int) (vRect, hRect image.Rectangle) {
+	figureWidth := max(min(bounds.Dx(), bounds.Dy())/2, 20)
+	figureHeight := figureWidth
+
+	lineWidth := figureHeight / 8
+	if lineWidth < 2 {
+		lineWidth = 2
+	}
+
+	vRect = image.Rect(
+		x-figureWidth/2,
+		y-figureHeight/2,
+		x-figureWidth/2+lineWidth,
+		y+figureHeight/2,
+	)
+	hRect = image.Rect(
+		x-figureWidth/2+lineWidth,
+		y-lineWidth/2,
+		x+figureWidth/2,
+		y+lineWidth/2,
+	)
+	return vRect, hRect
+}
+
+func drawFigure(c canvas, x, y int) {
+	vRect, hRect := FigureBars(c.Bounds(), x, y)
+	c.Fill(vRect, FigureColor, draw.Src)
+	c.Fill(hRect, FigureColor, draw.Src)
+}
+
+type imageCanvas struct {
+	*image.RGBA
+}
+
+func (c imageCanvas) Fill(dr image.Rectangle, src color.Color, op draw.Op) {
+	draw.Draw(c.RGBA, dr, image.NewUniform(src), image.Point{}, op)
+}
+
+// RenderImage draws the scene into a new image of state.WindowSize. It must
+// run on the loop goroutine, e.g. inside an OperationFunc.
+func RenderImage(state *LoopState) *image.RGBA {
+	img := image.NewRGBA(image.Rectangle{Max: state.WindowSize})
+	drawState(imageCanvas{img}, state)
+	return img
+}
@@ -0,0 +1,40 @@
+package painter
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderImage(t *testing.T) {
+	state := &LoopState{
+		Background: color.White,
+		BgRects: []BgRect{
+			{RelativeRectangle: RelativeRectangle{X2: 0.5, Y2: 0.5}, Color: color.Black},
+			{RelativeRectangle: RelativeRectangle{X1: 0.5, Y1: 0.5, X2: 1, Y2: 1}, Color: color.NRGBA{B: 0xff, A: 0x80}},
+		},
+		Figures:    []*Figure{{X: 0.75, Y: 0.25}},
+		WindowSize: image.Point{X: 200, Y: 100},
+	}
+
+	img := RenderImage(state)
+	assert.Equal(t, image.Rect(0, 0, 200, 100), img.Bounds())
+	assert.Equal(t, color.RGBA{A: 0xff}, img.RGBAAt(10, 10))
+	assert.Equal(t, color.RGBA{R: 0x7f, G: 0x7f, B: 0xff, A: 0xff}, img.RGBAAt(190, 90))
+	assert.Equal(t, color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}, img.RGBAAt(10, 90))
+
+	vRect, hRect := FigureBars(img.Bounds(), 150, 25)
+	assert.Equal(t, FigureColor, img.RGBAAt(vRect.Min.X, vRect.Min.Y+1))
+	assert.Equal(t, FigureColor, img.RGBAAt(hRect.Max.X-1, 25))
+}
+
+func TestFigureBars_KeepShape(t *testing.T) {
+	square, _ := FigureBars(image.Rect(0, 0, 100, 100), 50, 50)
+	for _, bounds := range []image.Rectangle{image.Rect(0, 0, 400, 100), image.Rect(0, 0, 100, 300)} {
+		vRect, hRect := FigureBars(bounds, 50, 50)
+		assert.Equal(t, square, vRect, "bounds %v", bounds)
+		assert.Equal(t, vRect.Dy(), hRect.Max.X-vRect.Min.X, "the T is as wide as it is tall")
+	}
+}
@@ -0,0 +1,266 @@
+package painter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/color"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// SceneVersion is the version written to and accepted from scene documents.
+const SceneVersion = 1
+
+// Scene is a copy of the drawable model held by LoopState. It does not share
+// memory with the state it was taken from.
+type Scene struct {
+	Background color.Color
+	BgRects    []BgRect
+	Figures    []Figure
+}
+
+func (s *LoopState) Scene() Scene {
+	sc := Scene{
+		Background: s.Background,
+		BgRects:    append([]BgRect(nil), s.BgRects...),
+		Figures:    make([]Figure, 0, len(s.Figures)),
+	}
+	for _, f := range s.Figures {
+		f := *f
+		f.Tags = append([]string(nil), f.Tags...)
+		sc.Figures = append(sc.Figures, f)
+	}
+	return sc
+}
+
+// SetScene replaces the scene with a copy of sc.
+func (s *LoopState) SetScene(sc Scene) {
+	s.Changed()
+	s.Background = sc.Background
+	if s.Background == nil {
+		s.Background = color.Black
+	}
+	s.BgRects = append([]BgRect(nil), sc.BgRects...)
+	s.Figures = make([]*Figure, 0, len(sc.Figures))
+	for _, f := range sc.Figures {
+		f := f
+		f.Tags = append([]string(nil), f.Tags...)
+		s.Figures = append(s.Figures, &f)
+	}
+}
+
+// Equal reports whether sc and other describe the same drawing.
+func (sc Scene) Equal(other Scene) bool {
+	if sc.Background != other.Background || len(sc.BgRects) != len(other.BgRects) || len(sc.Figures) != len(other.Figures) {
+		return false
+	}
+	for i, r := range sc.BgRects {
+		if r != other.BgRects[i] {
+			return false
+		}
+	}
+	for i, f := range sc.Figures {
+		o := other.Figures[i]
+		if f.X != o.X || f.Y != o.Y || f.ID != o.ID || !slices.Equal(f.Tags, o.Tags) {
+			return false
+		}
+	}
+	return true
+}
+
+type sceneDocument struct {
+	Version    int              `json:"version"`
+	Background string           `json:"background"`
+	BgRects    []bgRectDocument `json:"bgRects"`
+	Figures    []figureDocument `json:"figures"`
+}
+
+type bgRectDocument struct {
+	X1    float64 `json:"x1"`
+	Y1    float64 `json:"y1"`
+	X2    float64 `json:"x2"`
+	Y2    float64 `json:"y2"`
+	Color string  `json:"color"`
+}
+
+type figureDocument struct {
+	X    float64  `json:"x"`
+	Y    float64  `json:"y"`
+	ID   string   `json:"id,omitempty"`
+	Tags []string `json:"tags,omitempty"`
+}
+
+func (sc Scene) MarshalJSON() ([]byte, error) {
+	doc := sceneDocument{
+		Version:    SceneVersion,
+		Background: hexOrBlack(sc.Background),
+		BgRects:    make([]bgRectDocument, 0, len(sc.BgRects)),
+		Figures:    make([]figureDocument, 0, len(sc.Figures)),
+	}
+	for _, r := range sc.BgRects {
+		doc.BgRects = append(doc.BgRects, bgRectDocument{X1: r.X1, Y1: r.Y1, X2: r.X2, Y2: r.Y2, Color: hexOrBlack(r.Color)})
+	}
+	for _, f := range sc.Figures {
+		doc.Figures = append(doc.Figures, figureDocument{X: f.X, Y: f.Y, ID: f.ID, Tags: f.Tags})
+	}
+	return json.Marshal(doc)
+}
+
+func hexOrBlack(c color.Color) string {
+	if c == nil {
+		c = color.Black
+	}
+	return HexColor(c)
+}
+
+var namePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// ValidName reports whether s can be used as a figure id or tag: it starts
+// with a letter or '_' and goes on with letters, digits, '_', '.' and '-'.
+// "all" is reserved for selecting every figure.
+func ValidName(s string) bool {
+	return namePattern.MatchString(s) && !strings.EqualFold(s, "all")
+}
+
+func (sc *Scene) UnmarshalJSON(data []byte) error {
+	var doc sceneDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	switch {
+	case doc.Version == 0:
+		return errors.New("scene: missing version")
+	case doc.Version > SceneVersion:
+		return fmt.Errorf("scene: version %d is newer than the supported version %d", doc.Version, SceneVersion)
+	}
+
+	bg, err := ParseColor(doc.Background)
+	if err != nil {
+		return fmt.Errorf("scene: background: %w", err)
+	}
+	res := Scene{Background: bg}
+	for i, r := range doc.BgRects {
+		c, err := ParseColor(r.Color)
+		if err != nil {
+			return fmt.Errorf("scene: bgRects[%d]: %w", i, err)
+		}
+		res.BgRects = append(res.BgRects, BgRect{
+			RelativeRectangle: RelativeRectangle{X1: r.X1, Y1: r.Y1, X2: r.X2, Y2: r.Y2},
+			Color:             c,
+		})
+	}
+	ids := make(map[string]bool)
+	for i, f := range doc.Figures {
+		if f.ID != "" {
+			if !ValidName(f.ID) {
+				return fmt.Errorf("scene: figures[%d]: invalid id %q", i, f.ID)
+			}
+			if ids[f.ID] {
+				return fmt.Errorf("scene: figures[%d]: duplicate id %q", i, f.ID)
+			}
+			ids[f.ID] = true
+		}
+		for _, tag := range f.Tags {
+			if !ValidName(tag) {
+				return fmt.Errorf("scene: figures[%d]: invalid tag %q", i, tag)
+			}
+		}
+		res.Figures = append(res.Figures, Figure{X: f.X, Y: f.Y, ID: f.ID, Tags: f.Tags})
+	}
+	*sc = res
+	return nil
+}
+
+// SceneOperation replaces the whole scene.
+type SceneOperation struct {
+	Scene Scene
+}
+
+func (o SceneOperation) Do(state *LoopState) bool {
+	state.SetScene(o.Scene)
+	log.Printf("Scene replaced: %d background rectangles, %d figures", len(o.Scene.BgRects), len(o.Scene.Figures))
+	return false
+}
+
+// SaveOperation writes the scene as JSON to Path, resolved against
+// LoopState.SceneDir. The directory is created if it does not exist.
+type SaveOperation struct {
+	Path string
+}
+
+func (o SaveOperation) Do(state *LoopState) bool {
+	path := state.scenePath(o.Path)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		state.Fail(fmt.Errorf("cannot save scene: %w", err))
+		return false
+	}
+	if err := SaveScene(path, state.Scene()); err != nil {
+		state.Fail(fmt.Errorf("cannot save scene: %w", err))
+		return false
+	}
+	log.Printf("Scene saved to %s", path)
+	return false
+}
+
+// LoadOperation replaces the scene with the one stored at Path, resolved
+// against LoopState.SceneDir.
+type LoadOperation struct {
+	Path string
+}
+
+func (o LoadOperation) Do(state *LoopState) bool {
+	path := state.scenePath(o.Path)
+	sc, err := LoadScene(path)
+	if err != nil {
+		state.Fail(fmt.Errorf("cannot load scene: %w", err))
+		return false
+	}
+	state.SetScene(sc)
+	log.Printf("Scene loaded from %s", path)
+	return false
+}
+
+func (s *LoopState) scenePath(name string) string {
+	if s.SceneDir == "" {
+		return name
+	}
+	return filepath.Join(s.SceneDir, name)
+}
+
+// SaveScene writes sc to path atomically.
+func SaveScene(path string, sc Scene) error {
+	data, err := json.MarshalIndent(sc, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".scene-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func LoadScene(path string) (Scene, error) {
+	var sc Scene
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return sc, err
+	}
+	if err := json.Unmarshal(data, &sc); err != nil {
+		return sc, fmt.Errorf("%s: %w", path, err)
+	}
+	return sc, nil
+}
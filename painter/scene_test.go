@@ -0,0 +1,90 @@
+package painter
+
+import (
+	"encoding/json"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testScene() Scene {
+	return Scene{
+		Background: color.NRGBA{R: 0x10, G: 0x20, B: 0x30, A: 0xff},
+		BgRects: []BgRect{
+			{RelativeRectangle: RelativeRectangle{X1: 0.1, Y1: 0.2, X2: 0.3, Y2: 0.4}, Color: color.NRGBA{R: 0xff, A: 0x80}},
+		},
+		Figures: []Figure{
+			{X: 0.5, Y: 0.5},
+			{X: 0.25, Y: 0.75, ID: "a", Tags: []string{"top"}},
+		},
+	}
+}
+
+func TestScene_JSONRoundTrip(t *testing.T) {
+	data, err := json.Marshal(testScene())
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"version": 1,
+		"background": "#102030",
+		"bgRects": [{"x1": 0.1, "y1": 0.2, "x2": 0.3, "y2": 0.4, "color": "#ff000080"}],
+		"figures": [{"x": 0.5, "y": 0.5}, {"x": 0.25, "y": 0.75, "id": "a", "tags": ["top"]}]
+	}`, string(data))
+
+	var sc Scene
+	require.NoError(t, json.Unmarshal(data, &sc))
+	assert.Equal(t, testScene(), sc)
+}
+
+func TestScene_UnmarshalErrors(t *testing.T) {
+	for name, doc := range map[string]string{
+		"missing version": `{"background": "#000"}`,
+		"future version":  `{"version": 99, "background": "#000"}`,
+		"bad background":  `{"version": 1, "background": "nope"}`,
+		"bad rect color":  `{"version": 1, "background": "#000", "bgRects": [{"color": "nope"}]}`,
+		"duplicate id":    `{"version": 1, "background": "#000", "figures": [{"id": "a"}, {"id": "a"}]}`,
+		"id with digit":   `{"version": 1, "background": "#000", "figures": [{"id": "1a"}]}`,
+		"id with markup":  `{"version": 1, "background": "#000", "figures": [{"id": "a<b"}]}`,
+		"reserved id":     `{"version": 1, "background": "#000", "figures": [{"id": "all"}]}`,
+		"tag with space":  `{"version": 1, "background": "#000", "figures": [{"tags": ["a b"]}]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			var sc Scene
+			assert.Error(t, json.Unmarshal([]byte(doc), &sc))
+		})
+	}
+}
+
+func TestLoopState_SceneIsDeepCopy(t *testing.T) {
+	state := &LoopState{}
+	state.SetScene(testScene())
+	sc := state.Scene()
+
+	state.Figures[1].Tags[0] = "changed"
+	state.Figures[1].X = 0.9
+	state.BgRects[0].X1 = 0.9
+	assert.Equal(t, testScene(), sc)
+}
+
+func TestSaveAndLoadOperations(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "scenes")
+	state := &LoopState{SceneDir: dir}
+	state.SetScene(testScene())
+
+	SaveOperation{Path: "scene.json"}.Do(state)
+	require.FileExists(t, filepath.Join(dir, "scene.json"))
+
+	ResetOperation{}.Do(state)
+	require.Empty(t, state.Figures)
+
+	LoadOperation{Path: "scene.json"}.Do(state)
+	assert.Equal(t, testScene(), state.Scene())
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "broken.json"), []byte("{"), 0o644))
+	LoadOperation{Path: "broken.json"}.Do(state)
+	LoadOperation{Path: "missing.json"}.Do(state)
+	assert.Equal(t, testScene(), state.Scene(), "failed loads leave the scene untouched")
+}
@@ -0,0 +1,61 @@
+package painter
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"io"
+	"strings"
+)
+
+// WriteSVG exports the scene as an SVG document of state.WindowSize pixels.
+// Every element maps onto a rectangle the raster renderer fills, so both
+// outputs match exactly at that size and the SVG scales losslessly.
+func WriteSVG(w io.Writer, state *LoopState) error {
+	bw := bufio.NewWriter(w)
+	size := state.WindowSize
+	bounds := image.Rectangle{Max: size}
+
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+"\n",
+		size.X, size.Y, size.X, size.Y)
+	writeSVGRect(bw, "  ", bounds, state.Background, "")
+
+	for _, r := range state.BgRects {
+		writeSVGRect(bw, "  ", r.Pixels(bounds), r.Color, ` class="bgrect"`)
+	}
+
+	width, height := float64(size.X), float64(size.Y)
+	for _, f := range state.Figures {
+		attrs := ` class="figure"`
+		if len(f.Tags) > 0 {
+			attrs = ` class="figure ` + html.EscapeString(strings.Join(f.Tags, " ")) + `"`
+		}
+		if f.ID != "" {
+			attrs = ` id="` + html.EscapeString(f.ID) + `"` + attrs
+		}
+		fmt.Fprintf(bw, "  <g%s>\n", attrs)
+		vRect, hRect := FigureBars(bounds, int(f.X*width), int(f.Y*height))
+		writeSVGRect(bw, "    ", vRect, FigureColor, "")
+		writeSVGRect(bw, "    ", hRect, FigureColor, "")
+		fmt.Fprintln(bw, "  </g>")
+	}
+
+	fmt.Fprintln(bw, "</svg>")
+	return bw.Flush()
+}
+
+func writeSVGRect(w io.Writer, indent string, r image.Rectangle, c color.Color, attrs string) {
+	r = r.Canon()
+	if r.Empty() || c == nil {
+		return
+	}
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	fill := fmt.Sprintf(`fill="#%02x%02x%02x"`, n.R, n.G, n.B)
+	if n.A != 0xff {
+		fill += fmt.Sprintf(` fill-opacity="%.3g"`, float64(n.A)/0xff)
+	}
+	fmt.Fprintf(w, `%s<rect%s x="%d" y="%d" width="%d" height="%d" %s/>`+"\n",
+		indent, attrs, r.Min.X, r.Min.Y, r.Dx(), r.Dy(), fill)
+}
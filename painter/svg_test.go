@@ -0,0 +1,64 @@
+package painter
+
+import (
+	"bytes"
+	"encoding/xml"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteSVG(t *testing.T) {
+	state := &LoopState{
+		Background: color.White,
+		BgRects: []BgRect{
+			{RelativeRectangle: RelativeRectangle{X2: 0.5, Y2: 0.5}, Color: color.NRGBA{R: 0xff, A: 0x80}},
+			{RelativeRectangle: RelativeRectangle{X1: 0.5, Y1: 0.5, X2: 0.5, Y2: 0.5}, Color: color.Black},
+		},
+		Figures:    []*Figure{{X: 0.5, Y: 0.5, ID: "a<b", Tags: []string{"top"}}},
+		WindowSize: image.Point{X: 200, Y: 100},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteSVG(&buf, state))
+	out := buf.String()
+
+	var doc struct {
+		Width  int `xml:"width,attr"`
+		Height int `xml:"height,attr"`
+		Rects  []struct {
+			Fill string `xml:"fill,attr"`
+		} `xml:"rect"`
+		Groups []struct {
+			ID    string `xml:"id,attr"`
+			Class string `xml:"class,attr"`
+			Rects []struct {
+				X      int `xml:"x,attr"`
+				Height int `xml:"height,attr"`
+			} `xml:"rect"`
+		} `xml:"g"`
+	}
+	require.NoError(t, xml.Unmarshal(buf.Bytes(), &doc), out)
+	assert.Equal(t, 200, doc.Width)
+	assert.Equal(t, 100, doc.Height)
+	require.Len(t, doc.Rects, 2, "the empty rectangle is skipped")
+	assert.Equal(t, "#ffffff", doc.Rects[0].Fill)
+	assert.Contains(t, out, `<rect class="bgrect" x="0" y="0" width="100" height="50" fill="#ff0000" fill-opacity="0.502"/>`)
+
+	require.Len(t, doc.Groups, 1)
+	assert.Equal(t, "a<b", doc.Groups[0].ID)
+	assert.Equal(t, "figure top", doc.Groups[0].Class)
+	vRect, _ := FigureBars(image.Rect(0, 0, 200, 100), 100, 50)
+	require.Len(t, doc.Groups[0].Rects, 2)
+	assert.Equal(t, vRect.Min.X, doc.Groups[0].Rects[0].X)
+	assert.Equal(t, vRect.Dy(), doc.Groups[0].Rects[0].Height)
+}
+
+func TestHexColor(t *testing.T) {
+	assert.Equal(t, "#00ff00", HexColor(color.RGBA{G: 0xff, A: 0xff}))
+	assert.Equal(t, "#ff000080", HexColor(color.NRGBA{R: 0xff, A: 0x80}))
+	assert.Equal(t, "#000000", HexColor(color.Black))
+}
@@ -0,0 +1,106 @@
+package painter
+
+import (
+	"image"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/exp/shiny/screen"
+)
+
+// maxFreeTextures is how many returned textures the swap chain keeps for
+// reuse. With one frame on screen and one drawn into, two are enough.
+const maxFreeTextures = 2
+
+// Frame is a texture presented to attached receivers. The loop never draws
+// into it again until every receiver it was handed to has called Release;
+// the texture then goes back to the loop for reuse.
+type Frame struct {
+	screen.Texture
+	refs  atomic.Int32
+	chain *swapChain
+}
+
+// Release hands the frame back to the loop. Each receiver must call it
+// exactly once when it no longer reads the texture.
+func (f *Frame) Release() {
+	switch refs := f.refs.Add(-1); {
+	case refs == 0:
+		f.chain.recycle(f.Texture)
+	case refs < 0:
+		log.Println("Warning: Frame released more times than it was handed out")
+	}
+}
+
+// swapChain allocates the textures the loop draws into and takes back the
+// ones receivers have finished with. Textures come back on the receivers'
+// goroutines.
+type swapChain struct {
+	screen screen.Screen
+
+	mu     sync.Mutex
+	size   image.Point
+	free   []screen.Texture
+	closed bool
+}
+
+// acquire returns a texture of the given size, reusing a returned one when
+// possible. Its contents are undefined.
+func (c *swapChain) acquire(size image.Point) (screen.Texture, error) {
+	c.mu.Lock()
+	if size != c.size {
+		c.releaseFree()
+		c.size = size
+	}
+	if n := len(c.free); n > 0 {
+		t := c.free[n-1]
+		c.free = c.free[:n-1]
+		c.mu.Unlock()
+		return t, nil
+	}
+	c.mu.Unlock()
+	return c.screen.NewTexture(size)
+}
+
+func (c *swapChain) recycle(t screen.Texture) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || t.Bounds().Size() != c.size || len(c.free) >= maxFreeTextures {
+		t.Release()
+		return
+	}
+	c.free = append(c.free, t)
+}
+
+func (c *swapChain) releaseFree() {
+	for _, t := range c.free {
+		t.Release()
+	}
+	c.free = nil
+}
+
+// close releases the free textures and makes frames returned later be
+// released instead of kept.
+func (c *swapChain) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	c.releaseFree()
+}
+
+// swap presents the texture the loop has drawn into as a frame for refs
+// receivers and gives the loop a new back texture, drawn with the next
+// frame.
+func (l *Loop) swap(refs int) *Frame {
+	next, err := l.chain.acquire(l.state.WindowSize)
+	if err != nil {
+		log.Printf("Error: Cannot allocate back texture, frame not presented: %v", err)
+		return nil
+	}
+	f := &Frame{Texture: l.state.Texture, chain: l.chain}
+	f.refs.Store(int32(refs))
+	l.state.Texture = next
+	l.dirty = true
+	return f
+}
@@ -0,0 +1,115 @@
+package painter
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"golang.org/x/exp/shiny/screen"
+)
+
+// countingTexture records how often it is drawn into and released.
+type countingTexture struct {
+	screen   *countingScreen
+	size     image.Point
+	fills    atomic.Int32
+	released atomic.Int32
+}
+
+func (t *countingTexture) Release()                                           { t.released.Add(1) }
+func (t *countingTexture) Size() image.Point                                  { return t.size }
+func (t *countingTexture) Bounds() image.Rectangle                            { return image.Rectangle{Max: t.size} }
+func (t *countingTexture) Upload(image.Point, screen.Buffer, image.Rectangle) {}
+func (t *countingTexture) Fill(dr image.Rectangle, _ color.Color, _ draw.Op) {
+	t.fills.Add(1)
+	if dr == t.Bounds() {
+		t.screen.repaints.Add(1)
+	}
+}
+
+// countingScreen hands out a new countingTexture for every NewTexture call
+// and counts the repaints of all of them, that is the fills of a whole
+// texture.
+type countingScreen struct {
+	screen.Screen
+	allocated atomic.Int32
+	repaints  atomic.Int32
+}
+
+func (s *countingScreen) NewTexture(size image.Point) (screen.Texture, error) {
+	s.allocated.Add(1)
+	return &countingTexture{screen: s, size: size}, nil
+}
+
+type frameReceiver chan screen.Texture
+
+func (r frameReceiver) Update(t screen.Texture) { r <- t }
+
+func TestLoop_FramesStayImmutable(t *testing.T) {
+	s := new(countingScreen)
+	l := NewLoop(s)
+	frames := make(frameReceiver, 10)
+	defer l.Attach(frames, EveryFrame)()
+
+	updates(t, l, 0)
+	first := (<-frames).(*Frame)
+	tex := first.Texture.(*countingTexture)
+	fills := tex.fills.Load()
+
+	l.Post(WhiteOperation{})
+	l.Post(FigureOperation{X: 0.2, Y: 0.2})
+	updates(t, l, 1)
+	second := (<-frames).(*Frame)
+	assert.Equal(t, fills, tex.fills.Load(), "a held frame is not drawn into")
+	assert.NotSame(t, first.Texture, second.Texture)
+	assert.Equal(t, int32(3), s.allocated.Load())
+
+	second.Release()
+	first.Release()
+	assert.Zero(t, tex.released.Load(), "returned textures are kept for reuse")
+
+	updates(t, l, 1)
+	third := (<-frames).(*Frame)
+	defer third.Release()
+	assert.Equal(t, int32(3), s.allocated.Load(), "returned textures are reused")
+
+	updates(t, l, 1)
+	fourth := (<-frames).(*Frame)
+	defer fourth.Release()
+	assert.Same(t, first.Texture, fourth.Texture)
+	assert.Greater(t, tex.fills.Load(), fills, "the returned texture is drawn into again")
+}
+
+func TestFrame_ReleasedByEveryReceiver(t *testing.T) {
+	s := new(countingScreen)
+	c := &swapChain{screen: s}
+	size := image.Pt(10, 10)
+	tex, err := c.acquire(size)
+	require.NoError(t, err)
+
+	f := &Frame{Texture: tex, chain: c}
+	f.refs.Store(2)
+	f.Release()
+	assert.Empty(t, c.free)
+	f.Release()
+	assert.Len(t, c.free, 1, "the texture returns after the last release")
+
+	again, err := c.acquire(size)
+	require.NoError(t, err)
+	assert.Same(t, tex, again)
+	assert.Equal(t, int32(1), s.allocated.Load())
+
+	c.recycle(again)
+	_, err = c.acquire(image.Pt(20, 20))
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), tex.(*countingTexture).released.Load(), "textures of the old size are released")
+
+	c.recycle(tex)
+	assert.Empty(t, c.free, "a texture of the old size is not kept")
+	assert.Equal(t, int32(2), tex.(*countingTexture).released.Load())
+}
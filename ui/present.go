@@ -0,0 +1,95 @@
+package ui
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strings"
+)
+
+// PresentMode controls how the painter texture is placed in the window when
+// their sizes differ.
+type PresentMode int
+
+const (
+	// Stretch scales the texture to cover the whole window, ignoring its
+	// aspect ratio.
+	Stretch PresentMode = iota
+	// Fit scales the texture to fit inside the window and letterboxes the rest.
+	Fit
+	// Fill scales the texture to cover the window and crops the overflow.
+	Fill
+	// Center shows the texture unscaled in the middle of the window.
+	Center
+)
+
+var presentModeNames = []string{"stretch", "fit", "fill", "center"}
+
+func (m PresentMode) String() string {
+	if m < 0 || int(m) >= len(presentModeNames) {
+		return fmt.Sprintf("PresentMode(%d)", int(m))
+	}
+	return presentModeNames[m]
+}
+
+func ParsePresentMode(s string) (PresentMode, error) {
+	for i, name := range presentModeNames {
+		if strings.EqualFold(s, name) {
+			return PresentMode(i), nil
+		}
+	}
+	return Stretch, fmt.Errorf("unknown presentation mode %q (want one of %s)", s, strings.Join(presentModeNames, ", "))
+}
+
+// Next returns the mode that follows m, wrapping around after the last one.
+func (m PresentMode) Next() PresentMode {
+	return PresentMode((int(m) + 1) % len(presentModeNames))
+}
+
+// layout returns the window rectangle dr that shows the texture rectangle sr
+// for a texture of size tex in a window of size win.
+func layout(m PresentMode, tex, win image.Point) (dr, sr image.Rectangle) {
+	full := image.Rectangle{Max: tex}
+	winRect := image.Rectangle{Max: win}
+	if tex.X <= 0 || tex.Y <= 0 || win.X <= 0 || win.Y <= 0 {
+		return winRect, full
+	}
+	scaleX := float64(win.X) / float64(tex.X)
+	scaleY := float64(win.Y) / float64(tex.Y)
+
+	switch m {
+	case Fit:
+		scale := math.Min(scaleX, scaleY)
+		size := image.Pt(int(math.Round(float64(tex.X)*scale)), int(math.Round(float64(tex.Y)*scale)))
+		return centered(winRect, size), full
+	case Fill:
+		scale := math.Max(scaleX, scaleY)
+		size := image.Pt(int(math.Round(float64(win.X)/scale)), int(math.Round(float64(win.Y)/scale)))
+		return winRect, centered(full, size)
+	case Center:
+		size := image.Pt(min(tex.X, win.X), min(tex.Y, win.Y))
+		return centered(winRect, size), centered(full, size)
+	default:
+		return winRect, full
+	}
+}
+
+func centered(outer image.Rectangle, size image.Point) image.Rectangle {
+	size = image.Pt(min(size.X, outer.Dx()), min(size.Y, outer.Dy()))
+	origin := outer.Min.Add(outer.Size().Sub(size).Div(2))
+	return image.Rectangle{Min: origin, Max: origin.Add(size)}
+}
+
+// toTexture maps a window point to relative texture coordinates. It reports
+// false for points outside the presented part of the texture.
+func toTexture(x, y float64, dr, sr image.Rectangle, tex image.Point) (relX, relY float64, ok bool) {
+	if dr.Empty() || tex.X <= 0 || tex.Y <= 0 {
+		return 0, 0, false
+	}
+	if x < float64(dr.Min.X) || x >= float64(dr.Max.X) || y < float64(dr.Min.Y) || y >= float64(dr.Max.Y) {
+		return 0, 0, false
+	}
+	tx := float64(sr.Min.X) + (x-float64(dr.Min.X))*float64(sr.Dx())/float64(dr.Dx())
+	ty := float64(sr.Min.Y) + (y-float64(dr.Min.Y))*float64(sr.Dy())/float64(dr.Dy())
+	return tx / float64(tex.X), ty / float64(tex.Y), true
+}
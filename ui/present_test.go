@@ -0,0 +1,66 @@
+package ui
+
+import (
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLayout(t *testing.T) {
+	tex := image.Pt(800, 800)
+	win := image.Pt(1000, 500)
+	tests := []struct {
+		mode   PresentMode
+		dr, sr image.Rectangle
+	}{
+		{Stretch, image.Rect(0, 0, 1000, 500), image.Rect(0, 0, 800, 800)},
+		{Fit, image.Rect(250, 0, 750, 500), image.Rect(0, 0, 800, 800)},
+		{Fill, image.Rect(0, 0, 1000, 500), image.Rect(0, 200, 800, 600)},
+		{Center, image.Rect(100, 0, 900, 500), image.Rect(0, 150, 800, 650)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode.String(), func(t *testing.T) {
+			dr, sr := layout(tt.mode, tex, win)
+			assert.Equal(t, tt.dr, dr)
+			assert.Equal(t, tt.sr, sr)
+		})
+	}
+}
+
+func TestToTexture(t *testing.T) {
+	tex := image.Pt(800, 800)
+	win := image.Pt(1000, 500)
+
+	dr, sr := layout(Fit, tex, win)
+	x, y, ok := toTexture(500, 250, dr, sr, tex)
+	require.True(t, ok)
+	assert.InDelta(t, 0.5, x, 1e-9)
+	assert.InDelta(t, 0.5, y, 1e-9)
+	_, _, ok = toTexture(100, 250, dr, sr, tex)
+	assert.False(t, ok, "click on the letterbox bar")
+
+	dr, sr = layout(Fill, tex, win)
+	x, y, ok = toTexture(0, 0, dr, sr, tex)
+	require.True(t, ok)
+	assert.InDelta(t, 0.0, x, 1e-9)
+	assert.InDelta(t, 0.25, y, 1e-9)
+
+	dr, sr = layout(Stretch, tex, win)
+	x, y, ok = toTexture(750, 125, dr, sr, tex)
+	require.True(t, ok)
+	assert.InDelta(t, 0.75, x, 1e-9)
+	assert.InDelta(t, 0.25, y, 1e-9)
+}
+
+func TestParsePresentMode(t *testing.T) {
+	for m := Stretch; m <= Center; m++ {
+		parsed, err := ParsePresentMode(m.String())
+		require.NoError(t, err)
+		assert.Equal(t, m, parsed)
+	}
+	_, err := ParsePresentMode("zoom")
+	assert.Error(t, err)
+	assert.Equal(t, Stretch, Center.Next())
+}
@@ -3,6 +3,7 @@ package ui
 import (
 	"github.com/gothicenemy/software-architecture-3/painter"
 	"image"
+	"image/color"
 	"image/draw"
 	"log"
 
@@ -15,14 +16,22 @@ import (
 )
 
 type Window struct {
-	Title            string
-	Debug            bool
+	Title   string
+	Debug   bool
+	Present PresentMode
+	// ResizeCanvas makes the canvas follow the window size. It is on by
+	// default; the canvas then always matches the window and Present only
+	// matters once it is turned off for a fixed canvas size.
+	ResizeCanvas     bool
 	window           screen.Window
 	events           chan interface{}
 	tx               chan screen.Texture
 	closeReq         chan struct{}
 	closed           chan struct{}
+	loopDone         chan struct{}
+	detach           func()
 	windowSize       size.Event
+	textureSize      image.Point
 	figureX, figureY int
 	painterLoop      *painter.Loop
 }
@@ -46,21 +55,23 @@ func NewWindow(s screen.Screen, p *painter.Loop) *Window {
 	log.Println("UI Shiny window created.")
 
 	w := &Window{
-		Title:       "Painter Final",
-		Debug:       true,
-		window:      win,
-		events:      make(chan interface{}),
-		tx:          make(chan screen.Texture),
-		closeReq:    make(chan struct{}),
-		closed:      make(chan struct{}),
-		figureX:     WindowWidth / 2,
-		figureY:     WindowHeight / 2,
-		windowSize:  size.Event{WidthPx: WindowWidth, HeightPx: WindowHeight},
-		painterLoop: p,
+		Title:        "Painter Final",
+		Debug:        true,
+		ResizeCanvas: true,
+		window:       win,
+		events:       make(chan interface{}),
+		tx:           make(chan screen.Texture),
+		closeReq:     make(chan struct{}),
+		closed:       make(chan struct{}),
+		loopDone:     make(chan struct{}),
+		figureX:      WindowWidth / 2,
+		figureY:      WindowHeight / 2,
+		windowSize:   size.Event{WidthPx: WindowWidth, HeightPx: WindowHeight},
+		painterLoop:  p,
 	}
 
 	if w.painterLoop != nil {
-		w.painterLoop.SetReceiver(w)
+		w.detach = w.painterLoop.Attach(w, painter.LatestFrame)
 	} else {
 		log.Println("Warning: ui.NewWindow received nil painterLoop")
 	}
@@ -105,6 +116,10 @@ func (w *Window) Loop() {
 		return
 	}
 	log.Println("Window event loop started.")
+	defer close(w.loopDone)
+	if w.detach != nil {
+		defer w.detach()
+	}
 
 	if w.painterLoop != nil {
 		w.painterLoop.Post(painter.UpdateOperation{})
@@ -126,12 +141,17 @@ func (w *Window) Loop() {
 				continue
 			}
 			if w.window != nil {
+				w.textureSize = t.Bounds().Size()
 				windowBounds := image.Rect(0, 0, w.windowSize.WidthPx, w.windowSize.HeightPx)
-				w.window.Scale(windowBounds, t, t.Bounds(), draw.Src, nil)
+				dr, sr := layout(w.Present, w.textureSize, windowBounds.Size())
+				if dr != windowBounds {
+					w.window.Fill(windowBounds, color.Black, draw.Src)
+				}
+				w.window.Scale(dr, t, sr, draw.Src, nil)
 				w.window.Publish()
-			} else {
-				t.Release()
 			}
+			// The frame goes back to the painter loop for reuse.
+			t.Release()
 		case <-w.closeReq:
 			log.Println("Close requested, exiting Window loop.")
 			return
@@ -154,24 +174,31 @@ func (w *Window) handleEvent(e interface{}) bool {
 			log.Println("Escape pressed received in handleEvent")
 			return true
 		}
+		if op := historyOperation(ev); op != nil && w.painterLoop != nil {
+			w.painterLoop.Post(op)
+			w.painterLoop.Post(painter.UpdateOperation{})
+		}
+		if ev.Code == key.CodeP && ev.Direction == key.DirPress {
+			w.Present = w.Present.Next()
+			log.Printf("Presentation mode: %s", w.Present)
+			if w.painterLoop != nil {
+				w.painterLoop.Post(painter.UpdateOperation{})
+			}
+		}
 	case mouse.Event:
 		if ev.Button == mouse.ButtonLeft && ev.Direction == mouse.DirPress {
 			w.figureX = int(ev.X)
 			w.figureY = int(ev.Y)
 			if w.painterLoop != nil && w.windowSize.WidthPx > 0 && w.windowSize.HeightPx > 0 {
-				relX := float64(ev.X) / float64(w.windowSize.WidthPx)
-				relY := float64(ev.Y) / float64(w.windowSize.HeightPx)
-				if relX < 0 {
-					relX = 0
-				}
-				if relX > 1 {
-					relX = 1
-				}
-				if relY < 0 {
-					relY = 0
+				tex := w.textureSize
+				if tex == (image.Point{}) {
+					tex = w.windowSize.Size()
 				}
-				if relY > 1 {
-					relY = 1
+				dr, sr := layout(w.Present, tex, w.windowSize.Size())
+				relX, relY, ok := toTexture(float64(ev.X), float64(ev.Y), dr, sr, tex)
+				if !ok {
+					log.Printf("Click at %.0f, %.0f is outside the picture, ignoring", ev.X, ev.Y)
+					return false
 				}
 				cmd := painter.MoveOperation{X: relX, Y: relY}
 				w.painterLoop.Post(cmd)
@@ -182,6 +209,9 @@ func (w *Window) handleEvent(e interface{}) bool {
 	case size.Event:
 		w.windowSize = ev
 		log.Printf("Resized to: %dx%d", ev.WidthPx, ev.HeightPx)
+		if w.ResizeCanvas && w.painterLoop != nil && ev.WidthPx > 0 && ev.HeightPx > 0 {
+			w.painterLoop.Post(painter.ResizeOperation{Size: ev.Size()})
+		}
 	case paint.Event:
 		if w.Debug {
 			log.Println("Paint event")
@@ -192,11 +222,14 @@ func (w *Window) handleEvent(e interface{}) bool {
 	return false
 }
 
+// Update hands t to the window loop. It is called on the delivery goroutine
+// of the painter loop and waits until the window takes the frame or exits.
+// The window releases the frame once it is on screen.
 func (w *Window) Update(t screen.Texture) {
 	select {
 	case w.tx <- t:
-	default:
-		log.Println("Warning: UI loop busy, skipping texture frame.")
+	case <-w.loopDone:
+		t.Release()
 	}
 }
 
@@ -210,3 +243,18 @@ func (w *Window) Stop() {
 }
 
 func (w *Window) Closed() <-chan struct{} { return w.closed }
+
+// historyOperation maps Ctrl+Z (or Cmd+Z) to undo and Ctrl+Y or
+// Ctrl+Shift+Z to redo.
+func historyOperation(ev key.Event) painter.Operation {
+	if ev.Direction != key.DirPress || ev.Modifiers&(key.ModControl|key.ModMeta) == 0 {
+		return nil
+	}
+	switch {
+	case ev.Code == key.CodeZ && ev.Modifiers&key.ModShift != 0, ev.Code == key.CodeY:
+		return painter.RedoOperation{}
+	case ev.Code == key.CodeZ:
+		return painter.UndoOperation{}
+	}
+	return nil
+}